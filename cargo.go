@@ -1,253 +1,203 @@
 package main
 
 import (
-	"encoding/json"
-	"fmt"
-	"io/ioutil"
+	"context"
+	"crypto/tls"
+	"flag"
 	"log"
 	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
-	"sync"
+	"syscall"
 	"time"
 
-	yaml "gopkg.in/yaml.v2"
+	"cargo/collector"
+
+	"google.golang.org/grpc"
 )
 
-var vizceral *Vizceral
-var mutex = &sync.Mutex{}
+var adminAddr = flag.String("admin-addr", "", "address for the admin listener, disabled when empty")
+var ingestAddr = flag.String("ingest-addr", "", "address serving the log, maintenance and drain endpoints, which the main listener then leaves out, disabled when empty")
+var udpAddr = flag.String("udp-addr", "", "address for the UDP line protocol ingestion listener, disabled when empty")
+var grpcAddr = flag.String("grpc-addr", "", "address for the gRPC ingestion listener, disabled when empty")
+var noStatic = flag.Bool("no-static", false, "do not serve the dashboard from the dist directory")
+var staticLimit = flag.Int("static-limit", 0, "maximum concurrent dashboard file requests, unlimited when 0")
+var overlay = flag.String("overlay", "", "config file merged over the primary conf.yaml")
+var startupDelay = flag.Duration("startup-delay", 0, "time to wait after loading the config before starting")
+var shutdownTimeout = flag.Duration("shutdown-timeout", 10*time.Second, "time allowed for requests and the final flush on shutdown")
+var verbose = flag.Bool("verbose", false, "log node, connection and class counts with each snapshot")
+var logFormat = flag.String("logformat", "text", "format of the log lines, text or json")
 
 func main() {
-	vizceral = new(Vizceral)
-	vizceral.NewVizceral()
-
-	fs := http.FileServer(http.Dir("dist"))
-	http.Handle("/", fs)
-	http.HandleFunc("/log/complete/", logCompletedConnection)
-	http.HandleFunc("/log/failed/", logFailedConnection)
-	http.HandleFunc("/get", get)
-	log.Fatal(http.ListenAndServe(":8080", nil))
-}
-
-// VizceralNode holds the metadata for a given app tier
-type VizceralNode struct {
-	Name      string `json:"name"`
-	Renderer  string `json:"renderer"`
-	MaxVolume int    `json:"maxVolume"`
-	Updated   int32  `json:"updated"`
-}
-
-// Metrics holds the count of traffic split into buckets
-type Metrics struct {
-	Normal  int `json:"normal"`
-	Danger  int `json:"danger"`
-	Warning int `json:"warning"`
-}
-
-// Sum returns the total count of observations for a set of Metrics
-func (m Metrics) Sum() int {
-	return m.Normal + m.Warning + m.Danger
-}
-
-// VizceralConnection holds the stats for a given src:dst pair
-// shadowMetrics holds the current minutes accumulating stats
-// Metrics holds the previous minutes complete stats
-type VizceralConnection struct {
-	Source        string  `json:"source"`
-	Target        string  `json:"target"`
-	Metrics       Metrics `json:"metrics"`
-	shadowMetrics Metrics
-}
-
-// VizceralNodes holds a map of VizceralNode
-// Each node key should be an app tier
-// VizceralNodes implements the MashalJSON interface to
-// convert the connections into a flat list
-type VizceralNodes struct {
-	nodes map[string]*VizceralNode
-}
-
-// VizceralConnections holds a map of VizceralMetrics
-// Each connection key should be a src:dst pair
-// VizceralConnections implements the MashalJSON interface to
-// convert the connections into a flat list
-type VizceralConnections struct {
-	connections map[string]*VizceralConnection
-}
-
-// Vizceral is a data structure that holds the traffic graph
-type Vizceral struct {
-	config        Config
-	Name          string               `json:"name"`
-	Renderer      string               `json:"renderer"`
-	Layout        string               `json:"layout"`
-	MaxVolume     int                  `json:"maxVolume"`
-	Updated       int32                `json:"updated"`
-	NodeMap       *VizceralNodes       `json:"nodes"`
-	ConnectionMap *VizceralConnections `json:"connections"`
-}
-
-// NewVizceral returns a new Vizceral object
-func (v *Vizceral) NewVizceral() *Vizceral {
-	v.Name = "Bottle application map"
-	v.Renderer = "region"
-	v.Layout = "ltrTree"
-	v.MaxVolume = 0
-	v.NodeMap = new(VizceralNodes)
-	v.NodeMap.nodes = make(map[string]*VizceralNode)
-	v.ConnectionMap = new(VizceralConnections)
-	v.ConnectionMap.connections = make(map[string]*VizceralConnection)
-
-	v.config.getConfig()
-	v.createScenario()
-	go v.snapshotLoop()
-	return v
-}
+	flag.Parse()
+	if err := collector.SetLogFormat(*logFormat); err != nil {
+		log.Fatal(err)
+	}
 
-func (v *Vizceral) createScenario() {
-	for tierName, tier := range v.config.Ships {
-		node := &VizceralNode{}
-		node.Name = tierName
-		node.Renderer = "region"
-		vizceral.NodeMap.nodes[tierName] = node
-		log.Printf("created tier %s (0)", tierName)
-		for _, con := range tier.Clients {
-			host, _, err := net.SplitHostPort(con)
-			if err != nil {
-				log.Fatalf("%s is not a valid remote host", con)
+	config := collector.LoadConfig(*overlay)
+	time.Sleep(*startupDelay)
+	if *verbose {
+		config.Verbose = true
+	}
+	tlsConfig, err := config.TLS.ServerConfig()
+	if err != nil {
+		log.Fatalf("tls: %v", err)
+	}
+	vizceral := collector.New(config)
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	if config.ReloadInterval > 0 && config.Path() != "" {
+		// The graph is built from the config at start, so shut down
+		// as on SIGTERM and let the supervisor restart cargo with the
+		// new one
+		collector.WatchFile(config.Path(), config.ReloadInterval, func() {
+			log.Printf("%s changed, exiting to reload", config.Path())
+			select {
+			case stop <- syscall.SIGTERM:
+			default:
 			}
-			log.Printf("creating connection %s:%s", tierName, host)
-			connectionHash := fmt.Sprintf("%s:%s", tierName, host)
-			connection := &VizceralConnection{}
-			connection.Source = tierName
-			connection.Target = host
-			v.ConnectionMap.connections[connectionHash] = connection
-		}
+		})
 	}
-}
 
-func (v *Vizceral) snapshotLoop() {
-	for {
-		time.Sleep(time.Minute)
-		volume := 0
-		for _, con := range v.ConnectionMap.connections {
-			// There is a race condition here that the original
-			// connection object may receive some new observations
-			// before we create a new metric instance, and therefore
-			// we might lose a few observations. To avoid, a mutex is used
-			// but I know that's not very "go like"
-			// TODO: use channels for concurrency
-			mutex.Lock()
-			con.Metrics = con.shadowMetrics
-			con.shadowMetrics = Metrics{}
-			mutex.Unlock()
+	var adminServer *http.Server
+	if *adminAddr != "" {
+		admin := http.NewServeMux()
+		vizceral.RegisterAdminHandlers(admin)
+		adminServer = newServer(*adminAddr, mount(config.BasePath, admin), tlsConfig)
+		go func() {
+			if err := serve(adminServer); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
+	}
 
-			volume += con.Metrics.Sum()
+	var grpcServer *grpc.Server
+	if *grpcAddr != "" {
+		lis, err := net.Listen("tcp", *grpcAddr)
+		if err != nil {
+			log.Fatalf("failed to listen on %s: %v", *grpcAddr, err)
 		}
-		v.MaxVolume = volume
-
-		v.updateTimestamp()
-
-		log.Printf("took a snapshot with total volume = %d", volume)
+		grpcServer = grpc.NewServer()
+		vizceral.RegisterGRPC(grpcServer)
+		go func() {
+			if err := grpcServer.Serve(lis); err != nil {
+				log.Fatal(err)
+			}
+		}()
 	}
-}
 
-func (v *Vizceral) updateTimestamp() {
-	now := int32(time.Now().Unix())
-	v.Updated = now
-	for _, node := range v.NodeMap.nodes {
-		node.Updated = now
+	var udpConn net.PacketConn
+	if *udpAddr != "" {
+		conn, err := net.ListenPacket("udp", *udpAddr)
+		if err != nil {
+			log.Fatalf("failed to listen on %s: %v", *udpAddr, err)
+		}
+		udpConn = conn
+		go func() {
+			if err := vizceral.ServeUDP(udpConn); err != nil {
+				log.Fatal(err)
+			}
+		}()
 	}
-}
 
-func logFailedConnection(w http.ResponseWriter, r *http.Request) {
-	connection := r.URL.Path[12:]
-	if con, ok := vizceral.ConnectionMap.connections[connection]; ok {
-		mutex.Lock()
-		con.shadowMetrics.Danger += 25
-		mutex.Unlock()
-	} else {
-		log.Printf("did not find connection: %s", connection)
-		w.WriteHeader(http.StatusNotAcceptable)
+	if !*noStatic {
+		fs := http.FileServer(http.Dir("dist"))
+		http.Handle("/", limitConcurrency(fs, *staticLimit))
 	}
-}
-
-func logCompletedConnection(w http.ResponseWriter, r *http.Request) {
-	connection := r.URL.Path[14:]
-	connection = strings.Trim(connection, "\n")
-	if con, ok := vizceral.ConnectionMap.connections[connection]; ok {
-		mutex.Lock()
-		con.shadowMetrics.Normal += 25
-		mutex.Unlock()
+	var ingestServer *http.Server
+	if *ingestAddr != "" {
+		ingest := http.NewServeMux()
+		vizceral.RegisterLogHandlers(ingest)
+		vizceral.RegisterHealthHandlers(ingest)
+		vizceral.RegisterControlHandlers(ingest)
+		vizceral.RegisterReadHandlers(http.DefaultServeMux)
+		ingestServer = newServer(*ingestAddr, mount(config.BasePath, ingest), tlsConfig)
+		go func() {
+			if err := serve(ingestServer); err != http.ErrServerClosed {
+				log.Fatal(err)
+			}
+		}()
 	} else {
-		log.Printf("did not find connection: %s", connection)
-		w.WriteHeader(http.StatusNotAcceptable)
+		vizceral.RegisterHandlers(http.DefaultServeMux)
 	}
-}
-
-func get(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Access-Control-Allow-Origin", "*")
-	w.Header().Set("Content-Type", "application/json")
-	vizceral.updateTimestamp()
-	err := json.NewEncoder(w).Encode(vizceral)
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write([]byte("500 - failed to convert vizceral data into JSON"))
-		return
+	collector.Graphs{vizceral.Name: vizceral}.RegisterHandlers(http.DefaultServeMux)
+	server := newServer(":8080", mount(config.BasePath, http.DefaultServeMux), tlsConfig)
+	go func() {
+		if err := serve(server); err != http.ErrServerClosed {
+			log.Fatal(err)
+		}
+	}()
+
+	<-stop
+	log.Printf("shutting down")
+	ctx, cancel := context.WithTimeout(context.Background(), *shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
 	}
-}
-
-// Ship holds one tiers in/out config
-type Ship struct {
-	Replicas int      `yaml:"replicas"`
-	Clients  []string `yaml:"clients"`
-	Servers  []int    `yaml:"servers"`
-}
-
-// Config holds the traffic generator settings
-type Config struct {
-	Ships map[string]Ship `yaml:"ships"`
-}
-
-func (c *Config) getConfig() *Config {
-
-	yamlFile, err := ioutil.ReadFile("conf.yaml")
-	if err != nil {
-		log.Printf("error opening #%v ", err)
-		yamlFile, err = ioutil.ReadFile("/etc/cargo/conf.yaml")
-		if err != nil {
-			log.Printf("error opening #%v ", err)
+	if ingestServer != nil {
+		if err := ingestServer.Shutdown(ctx); err != nil {
+			log.Printf("shutdown of the ingest listener: %v", err)
 		}
 	}
-	err = yaml.Unmarshal(yamlFile, c)
-	if err != nil {
-		log.Fatalf("Unmarshal: %v", err)
+	if adminServer != nil {
+		if err := adminServer.Shutdown(ctx); err != nil {
+			log.Printf("shutdown of the admin listener: %v", err)
+		}
 	}
-
-	fmt.Printf("Initialized with config = \n\n%s\n\n", yamlFile)
-	time.Sleep(2 * time.Second)
-
-	return c
+	if grpcServer != nil {
+		grpcServer.GracefulStop()
+	}
+	if udpConn != nil {
+		udpConn.Close()
+	}
+	vizceral.Flush(*shutdownTimeout)
 }
 
-// MarshalJSON flattens this map into an array
-func (nodes VizceralConnections) MarshalJSON() (resp []byte, err error) {
-	var listOfNodes []*VizceralConnection
+// newServer returns a server for handler on addr, using TLS when tlsConfig is set
+func newServer(addr string, handler http.Handler, tlsConfig *tls.Config) *http.Server {
+	return &http.Server{Addr: addr, Handler: handler, TLSConfig: tlsConfig}
+}
 
-	for _, con := range nodes.connections {
-		listOfNodes = append(listOfNodes, con)
+// serve runs server until it fails or is shut down
+func serve(server *http.Server) error {
+	if server.TLSConfig == nil {
+		return server.ListenAndServe()
 	}
-
-	return json.Marshal(listOfNodes)
+	return server.ListenAndServeTLS("", "")
 }
 
-// MarshalJSON flattens this map into an array
-func (nodes VizceralNodes) MarshalJSON() (resp []byte, err error) {
-	var listOfNodes []*VizceralNode
-
-	for _, node := range nodes.nodes {
-		listOfNodes = append(listOfNodes, node)
+// mount serves handler under the path prefix, stripping it so the
+// handlers and the connection keys they read from the path are
+// unaffected. It returns handler when prefix is empty or the root
+func mount(prefix string, handler http.Handler) http.Handler {
+	prefix = "/" + strings.Trim(prefix, "/")
+	if prefix == "/" {
+		return handler
 	}
+	log.Printf("serving under %s/", prefix)
+	mux := http.NewServeMux()
+	mux.Handle(prefix+"/", http.StripPrefix(prefix, handler))
+	return mux
+}
 
-	return json.Marshal(listOfNodes)
+// limitConcurrency serves at most n requests with handler at a time,
+// responding 503 to the rest. It returns handler when n is not positive
+func limitConcurrency(handler http.Handler, n int) http.Handler {
+	if n <= 0 {
+		return handler
+	}
+	sem := make(chan struct{}, n)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case sem <- struct{}{}:
+			defer func() { <-sem }()
+			handler.ServeHTTP(w, r)
+		default:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("503 - too many concurrent requests"))
+		}
+	})
 }
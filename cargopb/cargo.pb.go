@@ -0,0 +1,260 @@
+// Code generated by protoc-gen-go. DO NOT EDIT.
+// versions:
+// 	protoc-gen-go v1.36.11
+// 	protoc        v5.28.3
+// source: cargo.proto
+
+package cargopb
+
+import (
+	protoreflect "google.golang.org/protobuf/reflect/protoreflect"
+	protoimpl "google.golang.org/protobuf/runtime/protoimpl"
+	reflect "reflect"
+	sync "sync"
+	unsafe "unsafe"
+)
+
+const (
+	// Verify that this generated code is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(20 - protoimpl.MinVersion)
+	// Verify that runtime/protoimpl is sufficiently up-to-date.
+	_ = protoimpl.EnforceVersion(protoimpl.MaxVersion - 20)
+)
+
+// Outcome is the result of the logged requests
+type Outcome int32
+
+const (
+	Outcome_COMPLETE Outcome = 0
+	Outcome_FAILED   Outcome = 1
+)
+
+// Enum value maps for Outcome.
+var (
+	Outcome_name = map[int32]string{
+		0: "COMPLETE",
+		1: "FAILED",
+	}
+	Outcome_value = map[string]int32{
+		"COMPLETE": 0,
+		"FAILED":   1,
+	}
+)
+
+func (x Outcome) Enum() *Outcome {
+	p := new(Outcome)
+	*p = x
+	return p
+}
+
+func (x Outcome) String() string {
+	return protoimpl.X.EnumStringOf(x.Descriptor(), protoreflect.EnumNumber(x))
+}
+
+func (Outcome) Descriptor() protoreflect.EnumDescriptor {
+	return file_cargo_proto_enumTypes[0].Descriptor()
+}
+
+func (Outcome) Type() protoreflect.EnumType {
+	return &file_cargo_proto_enumTypes[0]
+}
+
+func (x Outcome) Number() protoreflect.EnumNumber {
+	return protoreflect.EnumNumber(x)
+}
+
+// Deprecated: Use Outcome.Descriptor instead.
+func (Outcome) EnumDescriptor() ([]byte, []int) {
+	return file_cargo_proto_rawDescGZIP(), []int{0}
+}
+
+type LogConnectionRequest struct {
+	state   protoimpl.MessageState `protogen:"open.v1"`
+	Source  string                 `protobuf:"bytes,1,opt,name=source,proto3" json:"source,omitempty"`
+	Target  string                 `protobuf:"bytes,2,opt,name=target,proto3" json:"target,omitempty"`
+	Outcome Outcome                `protobuf:"varint,3,opt,name=outcome,proto3,enum=cargo.Outcome" json:"outcome,omitempty"`
+	// count of requests with this outcome, 0 is treated as 1
+	Count int32 `protobuf:"varint,4,opt,name=count,proto3" json:"count,omitempty"`
+	// latency of the requests in milliseconds, if measured
+	LatencyMs     *float64 `protobuf:"fixed64,5,opt,name=latency_ms,json=latencyMs,proto3,oneof" json:"latency_ms,omitempty"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogConnectionRequest) Reset() {
+	*x = LogConnectionRequest{}
+	mi := &file_cargo_proto_msgTypes[0]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogConnectionRequest) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogConnectionRequest) ProtoMessage() {}
+
+func (x *LogConnectionRequest) ProtoReflect() protoreflect.Message {
+	mi := &file_cargo_proto_msgTypes[0]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogConnectionRequest.ProtoReflect.Descriptor instead.
+func (*LogConnectionRequest) Descriptor() ([]byte, []int) {
+	return file_cargo_proto_rawDescGZIP(), []int{0}
+}
+
+func (x *LogConnectionRequest) GetSource() string {
+	if x != nil {
+		return x.Source
+	}
+	return ""
+}
+
+func (x *LogConnectionRequest) GetTarget() string {
+	if x != nil {
+		return x.Target
+	}
+	return ""
+}
+
+func (x *LogConnectionRequest) GetOutcome() Outcome {
+	if x != nil {
+		return x.Outcome
+	}
+	return Outcome_COMPLETE
+}
+
+func (x *LogConnectionRequest) GetCount() int32 {
+	if x != nil {
+		return x.Count
+	}
+	return 0
+}
+
+func (x *LogConnectionRequest) GetLatencyMs() float64 {
+	if x != nil && x.LatencyMs != nil {
+		return *x.LatencyMs
+	}
+	return 0
+}
+
+type LogConnectionResponse struct {
+	state         protoimpl.MessageState `protogen:"open.v1"`
+	unknownFields protoimpl.UnknownFields
+	sizeCache     protoimpl.SizeCache
+}
+
+func (x *LogConnectionResponse) Reset() {
+	*x = LogConnectionResponse{}
+	mi := &file_cargo_proto_msgTypes[1]
+	ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+	ms.StoreMessageInfo(mi)
+}
+
+func (x *LogConnectionResponse) String() string {
+	return protoimpl.X.MessageStringOf(x)
+}
+
+func (*LogConnectionResponse) ProtoMessage() {}
+
+func (x *LogConnectionResponse) ProtoReflect() protoreflect.Message {
+	mi := &file_cargo_proto_msgTypes[1]
+	if x != nil {
+		ms := protoimpl.X.MessageStateOf(protoimpl.Pointer(x))
+		if ms.LoadMessageInfo() == nil {
+			ms.StoreMessageInfo(mi)
+		}
+		return ms
+	}
+	return mi.MessageOf(x)
+}
+
+// Deprecated: Use LogConnectionResponse.ProtoReflect.Descriptor instead.
+func (*LogConnectionResponse) Descriptor() ([]byte, []int) {
+	return file_cargo_proto_rawDescGZIP(), []int{1}
+}
+
+var File_cargo_proto protoreflect.FileDescriptor
+
+const file_cargo_proto_rawDesc = "" +
+	"\n" +
+	"\vcargo.proto\x12\x05cargo\"\xb9\x01\n" +
+	"\x14LogConnectionRequest\x12\x16\n" +
+	"\x06source\x18\x01 \x01(\tR\x06source\x12\x16\n" +
+	"\x06target\x18\x02 \x01(\tR\x06target\x12(\n" +
+	"\aoutcome\x18\x03 \x01(\x0e2\x0e.cargo.OutcomeR\aoutcome\x12\x14\n" +
+	"\x05count\x18\x04 \x01(\x05R\x05count\x12\"\n" +
+	"\n" +
+	"latency_ms\x18\x05 \x01(\x01H\x00R\tlatencyMs\x88\x01\x01B\r\n" +
+	"\v_latency_ms\"\x17\n" +
+	"\x15LogConnectionResponse*#\n" +
+	"\aOutcome\x12\f\n" +
+	"\bCOMPLETE\x10\x00\x12\n" +
+	"\n" +
+	"\x06FAILED\x10\x012W\n" +
+	"\tCollector\x12J\n" +
+	"\rLogConnection\x12\x1b.cargo.LogConnectionRequest\x1a\x1c.cargo.LogConnectionResponseB\x17Z\x15cargo/cargopb;cargopbb\x06proto3"
+
+var (
+	file_cargo_proto_rawDescOnce sync.Once
+	file_cargo_proto_rawDescData []byte
+)
+
+func file_cargo_proto_rawDescGZIP() []byte {
+	file_cargo_proto_rawDescOnce.Do(func() {
+		file_cargo_proto_rawDescData = protoimpl.X.CompressGZIP(unsafe.Slice(unsafe.StringData(file_cargo_proto_rawDesc), len(file_cargo_proto_rawDesc)))
+	})
+	return file_cargo_proto_rawDescData
+}
+
+var file_cargo_proto_enumTypes = make([]protoimpl.EnumInfo, 1)
+var file_cargo_proto_msgTypes = make([]protoimpl.MessageInfo, 2)
+var file_cargo_proto_goTypes = []any{
+	(Outcome)(0),                  // 0: cargo.Outcome
+	(*LogConnectionRequest)(nil),  // 1: cargo.LogConnectionRequest
+	(*LogConnectionResponse)(nil), // 2: cargo.LogConnectionResponse
+}
+var file_cargo_proto_depIdxs = []int32{
+	0, // 0: cargo.LogConnectionRequest.outcome:type_name -> cargo.Outcome
+	1, // 1: cargo.Collector.LogConnection:input_type -> cargo.LogConnectionRequest
+	2, // 2: cargo.Collector.LogConnection:output_type -> cargo.LogConnectionResponse
+	2, // [2:3] is the sub-list for method output_type
+	1, // [1:2] is the sub-list for method input_type
+	1, // [1:1] is the sub-list for extension type_name
+	1, // [1:1] is the sub-list for extension extendee
+	0, // [0:1] is the sub-list for field type_name
+}
+
+func init() { file_cargo_proto_init() }
+func file_cargo_proto_init() {
+	if File_cargo_proto != nil {
+		return
+	}
+	file_cargo_proto_msgTypes[0].OneofWrappers = []any{}
+	type x struct{}
+	out := protoimpl.TypeBuilder{
+		File: protoimpl.DescBuilder{
+			GoPackagePath: reflect.TypeOf(x{}).PkgPath(),
+			RawDescriptor: unsafe.Slice(unsafe.StringData(file_cargo_proto_rawDesc), len(file_cargo_proto_rawDesc)),
+			NumEnums:      1,
+			NumMessages:   2,
+			NumExtensions: 0,
+			NumServices:   1,
+		},
+		GoTypes:           file_cargo_proto_goTypes,
+		DependencyIndexes: file_cargo_proto_depIdxs,
+		EnumInfos:         file_cargo_proto_enumTypes,
+		MessageInfos:      file_cargo_proto_msgTypes,
+	}.Build()
+	File_cargo_proto = out.File
+	file_cargo_proto_goTypes = nil
+	file_cargo_proto_depIdxs = nil
+}
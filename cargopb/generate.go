@@ -0,0 +1,4 @@
+// Package cargopb holds the gRPC definitions for ingesting log events
+package cargopb
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative cargo.proto
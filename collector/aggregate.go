@@ -0,0 +1,181 @@
+package collector
+
+import (
+	"fmt"
+	"path"
+	"regexp"
+	"sort"
+)
+
+// AggregateConfig collapses replicas into their logical tier in /get
+// Sources and Targets map glob patterns, matched against node names
+// with path.Match, to the name shown instead. They are applied to the
+// source and target of connections independently, and connections
+// that end up between the same pair of nodes are summed. The collector
+// still keeps the connection of every replica
+// TargetRules group targets by regular expression, such as db-\d+ to
+// db, for host sets too dynamic to list. The first matching rule wins
+// over Targets, see AggregateRule. Unmatched targets keep their name
+type AggregateConfig struct {
+	Sources     map[string]string `yaml:"sources" json:"sources"`
+	Targets     map[string]string `yaml:"targets" json:"targets"`
+	TargetRules []AggregateRule   `yaml:"targetRules" json:"targetRules,omitempty"`
+}
+
+// AggregateRule shows the names matching the Pattern regular expression,
+// which must match the whole name, as Name. Name may refer to the groups
+// of the pattern, such as $1
+type AggregateRule struct {
+	Pattern string `yaml:"pattern" json:"pattern"`
+	Name    string `yaml:"name" json:"name"`
+}
+
+func (c AggregateConfig) enabled() bool {
+	return len(c.Sources) > 0 || len(c.Targets) > 0 || len(c.TargetRules) > 0
+}
+
+// compiledRule is an AggregateRule with its pattern compiled
+type compiledRule struct {
+	pattern *regexp.Regexp
+	name    string
+}
+
+// compileRules compiles the patterns of the rules
+func compileRules(rules []AggregateRule) ([]compiledRule, error) {
+	compiled := make([]compiledRule, 0, len(rules))
+	for _, rule := range rules {
+		pattern, err := regexp.Compile("^(?:" + rule.Pattern + ")$")
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", rule.Pattern, err)
+		}
+		compiled = append(compiled, compiledRule{pattern, rule.Name})
+	}
+	return compiled, nil
+}
+
+// targetName returns the name a target is shown under, from the first
+// matching rule, then the Targets patterns
+func (v *Vizceral) targetName(name string) string {
+	for _, rule := range v.targetRules {
+		if match := rule.pattern.FindStringSubmatchIndex(name); match != nil {
+			return string(rule.pattern.ExpandString(nil, rule.name, name, match))
+		}
+	}
+	return aggregateName(v.config.Aggregate.Targets, name)
+}
+
+// aggregateName returns the name of the first pattern, in sorted
+// order, matching name or name itself when none matches
+func aggregateName(patterns map[string]string, name string) string {
+	keys := make([]string, 0, len(patterns))
+	for pattern := range patterns {
+		keys = append(keys, pattern)
+	}
+	sort.Strings(keys)
+	for _, pattern := range keys {
+		if ok, _ := path.Match(pattern, name); ok {
+			return patterns[pattern]
+		}
+	}
+	return name
+}
+
+// aggregated returns a copy of the graph with the configured sources
+// and targets collapsed. Merged connections carry the worse class of
+// their replicas and no latency or availability, as percentiles and
+// the windows availability is computed over cannot be summed
+func (v *Vizceral) aggregated() *Vizceral {
+	agg := v.config.Aggregate
+	view := *v
+	view.NodeMap = &VizceralNodes{nodes: make(map[string]*VizceralNode)}
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+
+	// renamed maps every node to the names it is shown under
+	connected := make(map[string]bool)
+	renamed := make(map[string][]string)
+	for _, con := range v.ConnectionMap.connections {
+		source := aggregateName(agg.Sources, con.Source)
+		target := v.targetName(con.Target)
+		connected[con.Source], connected[con.Target] = true, true
+		renamed[con.Source] = append(renamed[con.Source], source)
+		renamed[con.Target] = append(renamed[con.Target], target)
+
+		key := v.key(source, target)
+		merged, ok := view.ConnectionMap.connections[key]
+		if !ok {
+			copied := con.copied()
+			copied.Source, copied.Target = source, target
+			// later replicas are added to the copy, so it must not share
+			// the custom buckets or notices of this one
+			copied.Metrics = Metrics{}
+			copied.Metrics.Add(con.Metrics)
+			copied.Notices = append([]Notice(nil), con.Notices...)
+			view.ConnectionMap.connections[key] = copied
+			continue
+		}
+		merged.Metrics.Add(con.Metrics)
+		merged.Dimensions = merged.Dimensions.merge(con.Dimensions)
+		merged.Class = worse(merged.Class, con.Class)
+		merged.Latency = nil
+		merged.Availability = nil
+		merged.ErrorRate = merged.Metrics.errorRate()
+		merged.WarnRate = merged.Metrics.warnRate()
+		if v.config.Rates {
+			merged.Rate = merged.Metrics.rate(merged.window())
+		}
+		if con.Bytes != nil {
+			bytes := Bytes{}
+			if merged.Bytes != nil {
+				bytes = *merged.Bytes
+			}
+			bytes.add(con.Bytes)
+			merged.Bytes = bytes.throughput()
+		}
+		if con.LatencySLO != nil {
+			slo := LatencySLO{}
+			if merged.LatencySLO != nil {
+				slo = *merged.LatencySLO
+			}
+			slo.Within += con.LatencySLO.Within
+			slo.Over += con.LatencySLO.Over
+			slo.Attainment = float64(slo.Within) / float64(slo.Within+slo.Over)
+			merged.LatencySLO = &slo
+		}
+		if con.Setup != nil {
+			setup := Metrics{}
+			if merged.Setup != nil {
+				setup.Add(*merged.Setup)
+			}
+			setup.Add(*con.Setup)
+			merged.Setup = &setup
+		}
+		merged.Notices = append(merged.Notices, con.Notices...)
+		if con.changed > merged.changed {
+			merged.changed = con.changed
+		}
+		if con.changedSequence > merged.changedSequence {
+			merged.changedSequence = con.changedSequence
+		}
+	}
+
+	for _, con := range view.ConnectionMap.connections {
+		v.label(con)
+	}
+
+	for _, node := range v.NodeMap.nodes {
+		names := renamed[node.Name]
+		if !connected[node.Name] {
+			names = []string{node.Name}
+		}
+		for _, name := range names {
+			if existing, ok := view.NodeMap.nodes[name]; ok {
+				existing.Class = worse(existing.Class, node.Class)
+				continue
+			}
+			copied := *node
+			copied.Name = name
+			view.NodeMap.nodes[name] = &copied
+		}
+	}
+	return &view
+}
@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// connectionMetadata annotates a live connection, such as with a
+// migration in progress. PATCH merges a body of
+// {"key": "web:db", "metadata": {"migration": "in progress"}} into the
+// annotations of the connection, a null value removing that one, and
+// DELETE /connection/metadata?key=web:db clears them all. Annotations
+// are kept across snapshots until cleared and served in the metadata
+// of the connection
+func (v *Vizceral) connectionMetadata(w http.ResponseWriter, r *http.Request) {
+	var key string
+	var patch map[string]interface{}
+	switch r.Method {
+	case http.MethodPatch:
+		var body struct {
+			Key      string                 `json:"key"`
+			Metadata map[string]interface{} `json:"metadata"`
+		}
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - body must be a JSON object of a key and metadata"))
+			return
+		}
+		key, patch = body.Key, body.Metadata
+	case http.MethodDelete:
+		key = r.URL.Query().Get("key")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if key == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - key is required"))
+		return
+	}
+
+	v.graphMu.Lock()
+	defer v.graphMu.Unlock()
+	con, ok := v.connection(key)
+	if !ok {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - connection not found"))
+		return
+	}
+	// The annotations are replaced rather than changed in place as
+	// views of the graph being encoded may share them
+	annotations := make(map[string]interface{}, len(con.annotations)+len(patch))
+	if r.Method == http.MethodPatch {
+		for name, value := range con.annotations {
+			annotations[name] = value
+		}
+	}
+	for name, value := range patch {
+		if value == nil {
+			delete(annotations, name)
+		} else {
+			annotations[name] = value
+		}
+	}
+	if len(annotations) == 0 {
+		annotations = nil
+	}
+	con.annotations = annotations
+	metadata := ConnectionMetadata{}
+	if con.Metadata != nil {
+		metadata = *con.Metadata
+	}
+	metadata.Annotations = annotations
+	con.Metadata = &metadata
+	if metadata.Volume == nil && len(metadata.FailureBreakdown) == 0 && annotations == nil {
+		con.Metadata = nil
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(metadata); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert metadata into JSON"))
+	}
+}
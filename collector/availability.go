@@ -0,0 +1,54 @@
+package collector
+
+const (
+	availabilityWindows  = "windows"
+	availabilityRequests = "requests"
+)
+
+// AvailabilityConfig keeps a rolling availability of every connection
+// over its last Windows snapshots, a longer view of its health than
+// its class. Mode is windows (default) for the fraction of the windows
+// without a danger request, or requests for the fraction of requests
+// that were not in danger. Windows without traffic are left out and
+// a connection without traffic in any of them has no availability.
+// It is disabled when Windows is 0
+type AvailabilityConfig struct {
+	Windows int    `yaml:"windows" json:"windows"`
+	Mode    string `yaml:"mode" json:"mode"`
+}
+
+// availabilityWindow holds the counts of one window of a connection
+type availabilityWindow struct {
+	danger int
+	total  int
+}
+
+// availability records a completed window and returns the
+// availability over the last Windows of them, nil when none of them
+// saw traffic
+func (con *VizceralConnection) availability(window Metrics, c AvailabilityConfig) *float64 {
+	con.availabilityWindows = append(con.availabilityWindows, availabilityWindow{window.Danger, window.Sum()})
+	if len(con.availabilityWindows) > c.Windows {
+		con.availabilityWindows = con.availabilityWindows[len(con.availabilityWindows)-c.Windows:]
+	}
+	var good, total int
+	for _, w := range con.availabilityWindows {
+		if w.total == 0 {
+			continue
+		}
+		if c.Mode == availabilityRequests {
+			good += w.total - w.danger
+			total += w.total
+			continue
+		}
+		if w.danger == 0 {
+			good++
+		}
+		total++
+	}
+	if total == 0 {
+		return nil
+	}
+	availability := float64(good) / float64(total)
+	return &availability
+}
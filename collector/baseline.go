@@ -0,0 +1,76 @@
+package collector
+
+// AdaptiveConfig classifies connections against their own learned
+// baseline error rate instead of the fixed Thresholds. The baseline is
+// the mean error rate of the last Windows snapshots with traffic
+// (default 60). A connection is warning at Warning times its baseline
+// (default 3) and danger at Danger times (default 6). Baselines below
+// Floor (default 0.01) are raised to it so that a connection which
+// never fails does not turn red on its first error
+type AdaptiveConfig struct {
+	Enabled bool    `yaml:"enabled" json:"enabled"`
+	Windows int     `yaml:"windows" json:"windows"`
+	Warning float64 `yaml:"warning" json:"warning"`
+	Danger  float64 `yaml:"danger" json:"danger"`
+	Floor   float64 `yaml:"floor" json:"floor"`
+}
+
+func (c AdaptiveConfig) windows() int {
+	if c.Windows <= 0 {
+		return 60
+	}
+	return c.Windows
+}
+
+// classOf compares the error rate of m with the baseline
+func (c AdaptiveConfig) classOf(b *baseline, m Metrics) string {
+	mean, ok := b.mean()
+	if !ok || m.Sum() == 0 {
+		return classNormal
+	}
+	floor, warning, danger := c.Floor, c.Warning, c.Danger
+	if floor <= 0 {
+		floor = 0.01
+	}
+	if warning <= 0 {
+		warning = 3
+	}
+	if danger <= 0 {
+		danger = 6
+	}
+	if mean < floor {
+		mean = floor
+	}
+	rate := m.errorRate()
+	switch {
+	case rate >= danger*mean:
+		return classDanger
+	case rate >= warning*mean:
+		return classWarning
+	}
+	return classNormal
+}
+
+// baseline holds the recent error rates of a connection
+type baseline struct {
+	rates []float64
+}
+
+// add records the error rate of a window, keeping the last size rates
+func (b *baseline) add(rate float64, size int) {
+	b.rates = append(b.rates, rate)
+	if len(b.rates) > size {
+		b.rates = append([]float64(nil), b.rates[len(b.rates)-size:]...)
+	}
+}
+
+func (b *baseline) mean() (float64, bool) {
+	if len(b.rates) == 0 {
+		return 0, false
+	}
+	sum := 0.0
+	for _, rate := range b.rates {
+		sum += rate
+	}
+	return sum / float64(len(b.rates)), true
+}
@@ -0,0 +1,56 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"strconv"
+)
+
+// Bytes holds the bytes a connection received and sent in a window
+// along with the throughput they amount to in bytes per second
+type Bytes struct {
+	In           int64   `json:"in"`
+	Out          int64   `json:"out"`
+	InPerSecond  float64 `json:"inPerSecond"`
+	OutPerSecond float64 `json:"outPerSecond"`
+}
+
+// add accumulates the counts of o
+func (b *Bytes) add(o *Bytes) {
+	b.In += o.In
+	b.Out += o.Out
+}
+
+// throughput returns the counts of a window along with their rates
+func (b Bytes) throughput() *Bytes {
+	seconds := snapshotInterval.Seconds()
+	b.InPerSecond = float64(b.In) / seconds
+	b.OutPerSecond = float64(b.Out) / seconds
+	return &b
+}
+
+// parseBytes reads the optional bytesIn and bytesOut query parameters,
+// returning nil when neither is set
+func parseBytes(r *http.Request) (*Bytes, error) {
+	query := r.URL.Query()
+	in, out := query.Get("bytesIn"), query.Get("bytesOut")
+	if in == "" && out == "" {
+		return nil, nil
+	}
+	b := &Bytes{}
+	for _, param := range []struct {
+		name  string
+		value string
+		count *int64
+	}{{"bytesIn", in, &b.In}, {"bytesOut", out, &b.Out}} {
+		if param.value == "" {
+			continue
+		}
+		n, err := strconv.ParseInt(param.value, 10, 64)
+		if err != nil || n < 0 {
+			return nil, fmt.Errorf("invalid %s %q", param.name, param.value)
+		}
+		*param.count = n
+	}
+	return b, nil
+}
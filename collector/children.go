@@ -0,0 +1,113 @@
+package collector
+
+import (
+	"log"
+	"time"
+)
+
+// childSeparator joins a tier and one of its services in the source
+// of a connection key, such as web/api:10.0.0.1
+const childSeparator = "/"
+
+// createChildren gives a tier with services the nested graph shown
+// when the tier is focused. Every service gets a connection to each
+// of the tier's targets, logging one also logs the tier's connection
+func (v *Vizceral) createChildren(tierName string, tier Ship) {
+	if len(tier.Services) == 0 {
+		return
+	}
+	if v.children == nil {
+		v.children = make(map[string]*VizceralConnection)
+	}
+	node := v.NodeMap.nodes[tierName]
+	node.Nodes = &VizceralNodes{nodes: make(map[string]*VizceralNode)}
+	node.Connections = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for _, service := range tier.Services {
+		node.Nodes.nodes[service] = &VizceralNode{Name: service, Renderer: "focusedChild"}
+	}
+	for _, parent := range v.ConnectionMap.connections {
+		if parent.Source != tierName {
+			continue
+		}
+		node.Nodes.nodes[parent.Target] = &VizceralNode{Name: parent.Target, Renderer: "focusedChild"}
+		for _, service := range tier.Services {
+			key := v.key(tierName+childSeparator+service, parent.Target)
+			con := &VizceralConnection{Source: service, Target: parent.Target, ingested: &ingested{}}
+			con.mu = v.lockFor(key)
+			con.parent = parent
+			con.suppressAlerts = parent.suppressAlerts
+			con.forceNormal = parent.forceNormal
+			con.sampleRate = parent.sampleRate
+			con.latencySLO = parent.latencySLO
+			v.children[key] = con
+			node.Connections.connections[v.key(service, parent.Target)] = con
+		}
+	}
+	log.Printf("created %d services of tier %s", len(tier.Services), tierName)
+}
+
+// connection returns the tier or service connection with the given
+// key, the caller must hold graphMu
+func (v *Vizceral) connection(key string) (*VizceralConnection, bool) {
+	if con, ok := v.ConnectionMap.connections[key]; ok {
+		return con, true
+	}
+	con, ok := v.children[key]
+	return con, ok
+}
+
+// snapshotChildren rotates and classifies the service connections and
+// their nodes, raising no alerts. The caller must hold graphMu
+func (v *Vizceral) snapshotChildren(now time.Time) {
+	for _, con := range v.children {
+		con.mu.Lock()
+		if con.shadowMetrics.Sum() > 0 {
+			con.lastSeen = now.Unix()
+		}
+		con.rotate(v.config)
+		con.mu.Unlock()
+
+		con.ErrorRate = con.Metrics.errorRate()
+		con.WarnRate = con.Metrics.warnRate()
+		if v.config.Rates {
+			con.Rate = con.Metrics.rate(con.window())
+		}
+		class := v.metricsClass(con)
+		con.class = class
+		con.Class = class
+		if con.forceNormal && class != "" {
+			con.Class = classNormal
+		}
+	}
+	for _, node := range v.NodeMap.nodes {
+		if node.Nodes == nil {
+			continue
+		}
+		classes := make(map[string]string)
+		node.MaxVolume = 0
+		for _, con := range node.Connections.connections {
+			if volume := con.Metrics.Sum(); volume > node.MaxVolume {
+				node.MaxVolume = volume
+			}
+			classes[con.Source] = worse(classes[con.Source], con.Class)
+			classes[con.Target] = worse(classes[con.Target], con.Class)
+		}
+		for name, child := range node.Nodes.nodes {
+			child.Class = classes[name]
+			_, child.Connected = classes[name]
+		}
+	}
+}
+
+// allConnections returns the tier and service connections, the
+// caller must hold graphMu
+func (v *Vizceral) allConnections() []*VizceralConnection {
+	cons := make([]*VizceralConnection, 0, len(v.ConnectionMap.connections)+len(v.children))
+	for _, con := range v.ConnectionMap.connections {
+		cons = append(cons, con)
+	}
+	for _, con := range v.children {
+		cons = append(cons, con)
+	}
+	return cons
+}
@@ -0,0 +1,89 @@
+package collector
+
+import (
+	"fmt"
+	"log"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+const (
+	colorErrorRate  = "errorRate"
+	colorWarnRate   = "warnRate"
+	colorP95Latency = "p95Latency"
+	colorExpression = "expression"
+)
+
+// ColorByConfig selects the metric that drives the class, and so the
+// color, of connections in place of Thresholds, Adaptive and Latency.
+// Metric is errorRate, warnRate, p95Latency in milliseconds, or
+// expression for the number Expression evaluates to, which can use the
+// fields of classEnv such as p99 / 1000 + errorRate. A connection is
+// warning once the metric reaches Warning and danger once it reaches
+// Danger, a threshold of 0 is disabled. Connections keep the error
+// based classes when Metric is empty
+type ColorByConfig struct {
+	Metric     string  `yaml:"metric" json:"metric"`
+	Expression string  `yaml:"expression" json:"expression"`
+	Warning    float64 `yaml:"warning" json:"warning"`
+	Danger     float64 `yaml:"danger" json:"danger"`
+}
+
+// compileColorBy checks the ColorBy config and compiles its
+// expression, nil when the metric is not an expression
+func compileColorBy(c ColorByConfig) (*vm.Program, error) {
+	switch c.Metric {
+	case "", colorErrorRate, colorWarnRate, colorP95Latency:
+		if c.Expression != "" {
+			return nil, fmt.Errorf("expression is only used with metric %s", colorExpression)
+		}
+		return nil, nil
+	case colorExpression:
+		if c.Expression == "" {
+			return nil, fmt.Errorf("metric %s needs an expression", colorExpression)
+		}
+		return expr.Compile(c.Expression, expr.Env(classEnv{}), expr.AsFloat64())
+	}
+	return nil, fmt.Errorf("unknown metric %s, expected %s, %s, %s or %s",
+		c.Metric, colorErrorRate, colorWarnRate, colorP95Latency, colorExpression)
+}
+
+// colorClass classifies a connection by the ColorBy metric. Connections
+// without traffic, or without latency for p95Latency, are normal and
+// ones whose expression fails are left unclassified
+func (v *Vizceral) colorClass(con *VizceralConnection, m Metrics) string {
+	c := v.config.ColorBy
+	if c.Warning == 0 && c.Danger == 0 {
+		return ""
+	}
+	if m.Sum() == 0 {
+		return classNormal
+	}
+	var value float64
+	switch c.Metric {
+	case colorErrorRate:
+		value = m.errorRate()
+	case colorWarnRate:
+		value = m.warnRate()
+	case colorP95Latency:
+		if con.Latency == nil {
+			return classNormal
+		}
+		value = con.Latency.P95
+	case colorExpression:
+		out, err := expr.Run(v.colorBy, v.classEnv(con, m))
+		if err != nil {
+			log.Printf("warning: colorBy of %s failed: %v", v.key(con.Source, con.Target), err)
+			return ""
+		}
+		value = out.(float64)
+	}
+	switch {
+	case c.Danger > 0 && value >= c.Danger:
+		return classDanger
+	case c.Warning > 0 && value >= c.Warning:
+		return classWarning
+	}
+	return classNormal
+}
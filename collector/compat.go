@@ -0,0 +1,84 @@
+package collector
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// RendererCompat downgrades renderers for dashboards older than Below,
+// a dotted version such as 4.0.0. Renderers maps a renderer name to
+// the one such dashboards understand. The version is read from the
+// ?vizceral= query parameter, or the X-Vizceral-Version header
+type RendererCompat struct {
+	Below     string            `yaml:"below" json:"below"`
+	Renderers map[string]string `yaml:"renderers" json:"renderers"`
+}
+
+// clientVersion returns the Vizceral version a request declares
+func clientVersion(r *http.Request) string {
+	if version := r.URL.Query().Get("vizceral"); version != "" {
+		return version
+	}
+	return r.Header.Get("X-Vizceral-Version")
+}
+
+// olderVersion reports whether the dotted version a is older than b
+// Missing or non numeric parts count as 0
+func olderVersion(a, b string) bool {
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) || i < len(bs); i++ {
+		var x, y int
+		if i < len(as) {
+			x, _ = strconv.Atoi(as[i])
+		}
+		if i < len(bs) {
+			y, _ = strconv.Atoi(bs[i])
+		}
+		if x != y {
+			return x < y
+		}
+	}
+	return false
+}
+
+// renderers returns the renderer substitutions for a client version,
+// nil when the renderers are passed through unchanged
+func (c Config) renderers(version string) map[string]string {
+	if version == "" {
+		return nil
+	}
+	var renderers map[string]string
+	for _, compat := range c.RendererCompat {
+		if !olderVersion(version, compat.Below) {
+			continue
+		}
+		if renderers == nil {
+			renderers = make(map[string]string)
+		}
+		for from, to := range compat.Renderers {
+			renderers[from] = to
+		}
+	}
+	return renderers
+}
+
+// withRenderers returns a copy of the graph with the renderers of
+// the graph and its nodes substituted
+func (v *Vizceral) withRenderers(renderers map[string]string) *Vizceral {
+	rename := func(renderer string) string {
+		if to, ok := renderers[renderer]; ok {
+			return to
+		}
+		return renderer
+	}
+	view := *v
+	view.Renderer = rename(v.Renderer)
+	view.NodeMap = &VizceralNodes{nodes: make(map[string]*VizceralNode)}
+	for key, node := range v.NodeMap.nodes {
+		renamed := *node
+		renamed.Renderer = rename(node.Renderer)
+		view.NodeMap.nodes[key] = &renamed
+	}
+	return &view
+}
@@ -0,0 +1,696 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"sort"
+	"time"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// Ship holds one tiers in/out config
+// Connections holds optional settings keyed by client host
+// Group is the layer the tier is laid out in, such as frontend or
+// data. When any tier has one the others are in the default group
+// Type tells the dashboard which icon to draw the tier with, the
+// recommended values are service, db, cache, queue and external
+// Services are the services making up the tier, which Vizceral shows
+// when the tier is focused. Requests of a service are logged against
+// tier/service:target and also count towards the tier
+// SLO is the percentage of successful requests the tier targets, such
+// as 99.9. Its node is shown in danger with a notice while the requests
+// of its connections in the window fall short of it. 0 disables it
+// Capacity is the requests per second the tier can take, its node
+// shows its inbound rate as a saturation percentage, see
+// SaturationConfig. 0 leaves the saturation unknown
+type Ship struct {
+	Replicas    int                         `yaml:"replicas,omitempty" json:"replicas"`
+	Clients     []string                    `yaml:"clients" json:"clients"`
+	Servers     []int                       `yaml:"servers,omitempty" json:"servers"`
+	Connections map[string]ConnectionConfig `yaml:"connections,omitempty" json:"connections,omitempty"`
+	Group       string                      `yaml:"group,omitempty" json:"group,omitempty"`
+	Type        string                      `yaml:"type,omitempty" json:"type,omitempty"`
+	Services    []string                    `yaml:"services,omitempty" json:"services,omitempty"`
+	SLO         float64                     `yaml:"slo,omitempty" json:"slo,omitempty"`
+	Capacity    float64                     `yaml:"capacity,omitempty" json:"capacity,omitempty"`
+}
+
+// ConnectionConfig holds per connection settings
+// SuppressAlerts excludes the connection from class change alerts
+// ForceNormal always displays the connection as normal, the
+// metrics are still tracked as usual
+// Deadman shows the connection in danger with a no data notice once
+// nothing has been logged for it for that long, overriding the global
+// Deadman, for critical dependencies whose silence means a broken
+// reporter rather than no traffic
+// ExpectedRate is the requests per second the connection normally
+// sees, see ExpectedVolumeConfig
+// SampleRate is the fraction of requests its clients log, such as 0.1
+// for one in ten. Everything logged against the connection counts
+// 1/SampleRate times so that its volume is that of all its traffic.
+// There is no per request weight, the counts posted to /log/ are
+// multiplied the same way
+// LatencySLOMs splits the requests logged with a latency into those
+// within and over it, see LatencySLO
+type ConnectionConfig struct {
+	SuppressAlerts bool          `yaml:"suppressAlerts" json:"suppressAlerts"`
+	ForceNormal    bool          `yaml:"forceNormal" json:"forceNormal"`
+	Deadman        time.Duration `yaml:"deadman,omitempty" json:"deadman,omitempty"`
+	ExpectedRate   float64       `yaml:"expectedRate,omitempty" json:"expectedRate,omitempty"`
+	SampleRate     float64       `yaml:"sampleRate,omitempty" json:"sampleRate,omitempty"`
+	LatencySLOMs   float64       `yaml:"latencySloMs,omitempty" json:"latencySloMs,omitempty"`
+}
+
+// Thresholds are the danger ratios at which a connection becomes
+// warning or danger. Classification is disabled when both are 0
+// Connections with fewer than MinObservations logged requests in
+// a window are always normal, so a single failure on a quiet
+// connection does not turn it red
+type Thresholds struct {
+	Warning         float64 `yaml:"warning" json:"warning"`
+	Danger          float64 `yaml:"danger" json:"danger"`
+	MinObservations int     `yaml:"minObservations" json:"minObservations"`
+}
+
+func (t Thresholds) classOf(m Metrics) string {
+	if t.Warning == 0 && t.Danger == 0 {
+		return ""
+	}
+	if m.Sum() == 0 {
+		return classNormal
+	}
+	ratio := m.errorRate()
+	switch {
+	case t.Danger > 0 && ratio >= t.Danger:
+		return classDanger
+	case t.Warning > 0 && ratio >= t.Warning:
+		return classWarning
+	}
+	return classNormal
+}
+
+// BufferConfig enables channel based ingestion
+// Size is the capacity of the normal queue, 0 disables buffering
+// DangerSize is the capacity of the danger queue, defaulting to Size
+// Policy is what happens to an increment when its queue is full, drop
+// (default) counts it as dropped and block waits for room. MaxWait
+// bounds how long a blocked log request waits before it is answered
+// 503 and counted as timed out, so a stalled consumer cannot hold every
+// handler. A MaxWait of 0 waits indefinitely
+type BufferConfig struct {
+	Size       int           `yaml:"size" json:"size"`
+	DangerSize int           `yaml:"dangerSize" json:"dangerSize"`
+	Policy     string        `yaml:"policy" json:"policy"`
+	MaxWait    time.Duration `yaml:"maxWait" json:"maxWait"`
+}
+
+// Policies for an increment whose ingestion queue is full
+const (
+	bufferDrop  = "drop"
+	bufferBlock = "block"
+)
+
+// EntryConfig adds an entry node representing external traffic
+// with connections into each of the Targets tiers. Name defaults to
+// INTERNET and Renderer to focusedChild, independent of the renderer
+// used for the graph and its tiers. Origins split the entry traffic by
+// the address of its clients into nodes of their own, also connected
+// to the Targets, and the entry node keeps the traffic they do not
+// match
+type EntryConfig struct {
+	Enabled  bool          `yaml:"enabled" json:"enabled"`
+	Name     string        `yaml:"name" json:"name"`
+	Renderer string        `yaml:"renderer" json:"renderer"`
+	Targets  []string      `yaml:"targets" json:"targets"`
+	Origins  []EntryOrigin `yaml:"origins" json:"origins"`
+}
+
+func (c EntryConfig) name() string {
+	if c.Name == "" {
+		return "INTERNET"
+	}
+	return c.Name
+}
+
+func (c EntryConfig) renderer() string {
+	if c.Renderer == "" {
+		return "focusedChild"
+	}
+	return c.Renderer
+}
+
+// TimestampConfig adds an updatedAt RFC3339 string next to the
+// numeric updated field, formatted in Timezone which is an IANA
+// name such as Europe/London and defaults to UTC
+type TimestampConfig struct {
+	RFC3339  bool   `yaml:"rfc3339" json:"rfc3339"`
+	Timezone string `yaml:"timezone" json:"timezone"`
+}
+
+// Modes for computing the graph MaxVolume
+const (
+	volumeSum        = "sum"
+	volumeMax        = "max"
+	volumePercentile = "percentile"
+)
+
+// VolumeConfig selects how the graph MaxVolume is derived from the
+// connection volumes. Mode is one of sum (default), max or percentile.
+// In percentile mode MaxVolume is the Percentile (default 95) of the
+// connection volumes multiplied by the number of connections
+// Cap, when set, clamps the volume of each connection before MaxVolume
+// is derived and in /get, so one runaway connection does not flatten
+// the others. A capped connection keeps its true volume in metadata
+// Weights multiplies the buckets, such as danger: 3, in the metrics
+// /get displays and the volumes MaxVolume is derived from, so the
+// edges emphasize failures. Buckets without a weight count once and
+// the accounting of the metrics is unchanged
+// PerClass adds maxVolumes to /get, the MaxVolume of each of the
+// normal, warning and danger buckets on its own, so a dashboard can
+// scale each class independently and make a few errors stand out
+type VolumeConfig struct {
+	Mode       string             `yaml:"mode" json:"mode"`
+	Percentile float64            `yaml:"percentile" json:"percentile"`
+	Cap        int                `yaml:"cap" json:"cap"`
+	Weights    map[string]float64 `yaml:"weights" json:"weights,omitempty"`
+	PerClass   bool               `yaml:"perClass" json:"perClass"`
+}
+
+// weighted returns the metrics with every bucket multiplied by its weight
+func (c VolumeConfig) weighted(m Metrics) Metrics {
+	if len(c.Weights) == 0 {
+		return m
+	}
+	weigh := func(bucket string, n int) int {
+		if weight, ok := c.Weights[bucket]; ok {
+			return int(float64(n) * weight)
+		}
+		return n
+	}
+	out := Metrics{
+		Normal:  weigh(classNormal, m.Normal),
+		Warning: weigh(classWarning, m.Warning),
+		Danger:  weigh(classDanger, m.Danger),
+	}
+	for bucket, n := range m.Custom {
+		out.addBucket(bucket, weigh(bucket, n))
+	}
+	return out
+}
+
+// capped returns volume clamped to the configured cap
+func (c VolumeConfig) capped(volume int) int {
+	if c.Cap > 0 && volume > c.Cap {
+		return c.Cap
+	}
+	return volume
+}
+
+func (c VolumeConfig) maxVolume(volumes []int) int {
+	switch c.Mode {
+	case volumeMax:
+		max := 0
+		for _, volume := range volumes {
+			if volume > max {
+				max = volume
+			}
+		}
+		return max
+	case volumePercentile:
+		if len(volumes) == 0 {
+			return 0
+		}
+		p := c.Percentile
+		if p <= 0 || p > 100 {
+			p = 95
+		}
+		sorted := append([]int(nil), volumes...)
+		sort.Ints(sorted)
+		i := int(float64(len(sorted)-1) * p / 100)
+		return sorted[i] * len(sorted)
+	}
+	sum := 0
+	for _, volume := range volumes {
+		sum += volume
+	}
+	return sum
+}
+
+// DangerFloorConfig makes connections that are mostly failing stand
+// out regardless of their volume. Connections with an error rate of
+// at least ErrorRate (0 disables) are displayed in danger, with a
+// danger volume of at least Volume, defaulting to a twentieth of the
+// graph MaxVolume. Only the served metrics change
+type DangerFloorConfig struct {
+	ErrorRate float64 `yaml:"errorRate" json:"errorRate"`
+	Volume    int     `yaml:"volume" json:"volume"`
+}
+
+// volume returns the least danger volume of a boosted connection
+func (c DangerFloorConfig) volume(maxVolume int) int {
+	if c.Volume > 0 {
+		return c.Volume
+	}
+	return maxVolume / 20
+}
+
+// Config holds the traffic generator settings
+// LockShards splits connection locking across that many mutexes,
+// 0 keeps a single global lock, and HotLocks gives the most contended
+// connections their own, see HotLocksConfig
+// PlaceholderNodes creates a node for connection targets that are
+// not a defined tier, instead of only warning about them
+// StaleAfter is the age of the last snapshot after which the graph
+// is reported as stale, defaulting to twice the snapshot interval
+// TLS configures the HTTP listeners, see TLSConfig
+// Verbose adds node, connection and class counts to the snapshot log
+// Entry adds an entry node for external traffic, see EntryConfig
+// RollingWindows displays the sum of that many recent snapshot
+// windows instead of only the latest one
+// Latency classifies connections by their p95 latency. When both
+// Thresholds and Latency are set a connection takes the worse of
+// its error based and latency based class
+// NodeVolumes adds the inbound and outbound volume to node metadata
+// Outbound configures the HTTP client used by integrations such as
+// the Webhook receiving class changes
+// Buckets names the buckets requests can be logged into, defaulting
+// to normal, warning and danger. Only danger drives classification
+// History is the number of past snapshots served by /history
+// ReverseDNS displays IP targets by hostname, see ReverseDNSConfig
+// UnknownConnections handles log requests for connections that do not
+// exist: reject (default) responds 406, create adds the connection
+// and its nodes, ignore responds 202 and only counts the request
+// Loopback adds a cargo:cargo connection that is logged through the
+// normal ingestion path after every snapshot, proving it works
+// Adaptive replaces Thresholds with per connection learned baselines
+// TrustedProxies lists the IPs and CIDRs of proxies whose
+// X-Forwarded-For header is used to find the real client address
+// MaxKeyLength is the longest connection key the log endpoints
+// accept, defaulting to 256
+// HoldEmpty keeps the previous metrics of a connection for one
+// extra window when it sees no traffic, smoothing brief gaps
+// DuplicateConnections handles clients that generate a connection key
+// already created: warn (default) logs and replaces the connection,
+// merge keeps the first one and fail refuses to start
+// Timestamps adds human readable timestamps, see TimestampConfig
+// HistoryRetention evicts snapshots older than it from /history, such
+// as 24h. It can be used instead of, or along with, History
+// DangerFloor boosts failing connections, see DangerFloorConfig
+// HideIdleNodes leaves the nodes without traffic in the current window
+// out of /get, they are kept and shown again once traffic appears
+// SustainedDanger is the number of consecutive snapshots a connection
+// must spend in danger before a notice is attached to it, 0 disables
+// Anonymize replaces node names in /get with stable pseudonyms, the
+// admin endpoint /anonymize/mapping decodes them
+// ReloadInterval is how often the config file is checked for changes,
+// cargo exits on a change so that it is restarted with the new config.
+// Symlinks are followed on every check, see fileWatcher. 0 disables it
+// Aggregate collapses replicas in /get, see AggregateConfig
+// PrettyJSON indents /get by default, it can be set per request
+// with ?pretty=true|false
+// Warmup is how long after start class changes are not sent to the
+// Webhook, as the first windows of metrics are not meaningful
+// MaxDimensions bounds the labels logged with ?dim= that a connection
+// tracks per window, defaulting to 10. Later labels are counted as other
+// RendererCompat serves renderers older dashboards understand, see
+// RendererCompat. When several entries apply the later ones win
+// Clusters tracks the metrics of each connection by the ?cluster= it
+// was logged with, or default, so /get?cluster= can show one cluster
+// FlushOnShutdown takes a final snapshot on shutdown so the last
+// window reaches the outputs, see Vizceral.Flush
+// HMAC requires signed log requests, see HMACConfig. The secret is
+// never shown by /config
+// WarningAsDanger counts warnings as danger when classifying
+// connections, and therefore nodes. Their metrics are unchanged
+// MaxInFlight bounds the log requests handled at once, responding 503
+// to the others, 0 is unlimited
+// AlignSnapshots takes snapshots on wall clock minute boundaries, so
+// instances started at different times share the same windows
+// KeySeparator joins the source and target of connection keys, which
+// are source<separator>target, such as web:db. It defaults to ":",
+// a separator such as "|" avoids ambiguity with IPv6 targets
+// NormalizeReplicas divides the node volumes of NodeVolumes by the
+// Replicas of their tier, showing the load of a single replica
+// PagerDuty opens incidents for connections, see PagerDutyConfig
+// Rates adds the requests per second of each bucket to connections,
+// alongside their weighted Metrics
+// MaxConnections bounds the connections, once reached the created
+// connection observed least recently is evicted to make room for a new
+// one. Configured connections are never evicted. 0 is unlimited
+// AsyncLog answers log requests 202 as soon as they are queued, so the
+// connection is looked up later and unknown keys are only counted. It
+// requires buffered ingestion, see BufferConfig
+// FailureTypes names the kinds of failure /log/failed/ accepts with
+// ?type=, defaulting to timeout, refused and error. They are counted in
+// the failureBreakdown metadata of connections
+// DecayHalfLife displays an exponentially weighted moving average of
+// the windows instead of the latest one, where a window counts half as
+// much after each half life. It takes precedence over RollingWindows
+// MaxResponseBytes is the largest /get response served, larger graphs
+// are refused with 413. 0 is unlimited
+// NoticeHold keeps an automatic notice shown for at least this long
+// after its condition clears, showing the worst state seen meanwhile,
+// so a flapping connection does not gain and lose it every snapshot
+// Envoy maps the access log entries of /log/envoy onto connections,
+// see EnvoyConfig, and StatusCodes classifies their response codes
+// PreviewFirstWindow shows the in progress metrics in /get until the
+// first snapshot is taken, so traffic is visible right after start
+// StrictConnections only accepts the connections derived from the
+// config, any other key is refused with 403 and counted as forbidden
+// whatever UnknownConnections is set to
+// MinEdgeVolume leaves connections with fewer logged requests in the
+// window out of /get, they are still tracked and reappear once their
+// volume reaches it. 0 shows every connection
+// BasePath serves every endpoint and the dashboard under a path such
+// as /cargo, for a path based ingress. It defaults to the root
+// Dump periodically records the graph to a file or the log, see
+// DumpConfig
+// Deadman is the ConnectionConfig.Deadman of every connection without
+// one of its own, 0 disables it
+// ExpectedVolume flags connections straying from their expected rate,
+// see ExpectedVolumeConfig
+// Readiness is when /readyz reports cargo ready: listening as soon as
+// it serves requests, config (default) once the config is loaded and
+// snapshot once the first window has been committed. The config is
+// loaded before cargo listens, so the first two only differ in intent
+// MaxNotices bounds the notices of each node and connection, keeping
+// the most severe and, among those, the earliest. 0 is unlimited
+// Classification replaces Thresholds, Adaptive and Latency with an
+// expression evaluated against every connection after each snapshot,
+// such as errorRate > 0.2 || p95 > 500 ? "danger" : "normal". It
+// must return a class, see classEnv for the fields it can use
+// Discovery creates connections from the TCP connections of the host
+// or the tiers of a service discovery backend, see DiscoveryConfig
+// MetricsFormat is raw (default) to serve the weighted counts of each
+// window as connection metrics, or rate for requests per second, which
+// some dashboards and Vizceral setups fed by rate based sources expect.
+// Vizceral draws edges by their metrics relative to maxVolume, which is
+// converted along with them
+// LabelTemplate is a text/template giving the label of every
+// connection, such as {{.Source}} → {{.Target}} ({{printf "%.0f" .ErrorPercent}}% err),
+// see labelData for the fields it can use. Connections have no label
+// when it is empty
+// NodeSizing grows failing nodes, see NodeSizingConfig
+// ColorBy classifies connections by another metric than their error
+// rate, see ColorByConfig. It cannot be combined with Classification
+// Availability is the rolling availability of connections, see
+// AvailabilityConfig
+// LatencyHistogram serves the latencies of connections as a Prometheus
+// histogram, see HistogramConfig
+// KeepPorts keeps the port of clients in the target of their
+// connection, so web with clients db:5432 and db:6379 has the
+// connections web:db:5432 and web:db:6379 to two nodes rather than one
+// to db. Keys are split at their first separator, so the port needs
+// no escaping. Ship.Connections are looked up by host:port and then
+// by host
+// Settling keeps connections normal for that long after they are
+// created, when reconnecting after a deploy is noisy. Their metrics
+// of that period are served as setup and left out of their rolling
+// windows, alerts, baselines and deadman, see settle
+// Sequence serves the number of the latest snapshot as sequence, the
+// version of /get as a weak ETag honouring If-None-Match and accepts it
+// as ?sequence= on /get/delta
+// Saturation colors tiers nearing their Ship.Capacity, see
+// SaturationConfig
+type Config struct {
+	Ships      map[string]Ship `yaml:"ships" json:"ships"`
+	Buffer     BufferConfig    `yaml:"buffer" json:"buffer"`
+	LockShards int             `yaml:"lockShards" json:"lockShards"`
+	Thresholds Thresholds      `yaml:"thresholds" json:"thresholds"`
+	Volume     VolumeConfig    `yaml:"volume" json:"volume"`
+
+	PlaceholderNodes bool              `yaml:"placeholderNodes" json:"placeholderNodes"`
+	StaleAfter       time.Duration     `yaml:"staleAfter" json:"staleAfter"`
+	TLS              TLSConfig         `yaml:"tls" json:"tls"`
+	Verbose          bool              `yaml:"verbose" json:"verbose"`
+	Entry            EntryConfig       `yaml:"entry" json:"entry"`
+	RollingWindows   int               `yaml:"rollingWindows" json:"rollingWindows"`
+	Latency          LatencyThresholds `yaml:"latency" json:"latency"`
+	NodeVolumes      bool              `yaml:"nodeVolumes" json:"nodeVolumes"`
+	Outbound         OutboundConfig    `yaml:"outbound" json:"outbound"`
+	Webhook          WebhookConfig     `yaml:"webhook" json:"webhook"`
+	Buckets          []string          `yaml:"buckets" json:"buckets"`
+	History          int               `yaml:"history" json:"history"`
+	ReverseDNS       ReverseDNSConfig  `yaml:"reverseDNS" json:"reverseDNS"`
+
+	UnknownConnections string         `yaml:"unknownConnections" json:"unknownConnections"`
+	Loopback           bool           `yaml:"loopback" json:"loopback"`
+	Adaptive           AdaptiveConfig `yaml:"adaptive" json:"adaptive"`
+	TrustedProxies     []string       `yaml:"trustedProxies" json:"trustedProxies"`
+	MaxKeyLength       int            `yaml:"maxKeyLength" json:"maxKeyLength"`
+	HoldEmpty          bool           `yaml:"holdEmpty" json:"holdEmpty"`
+
+	DuplicateConnections string               `yaml:"duplicateConnections" json:"duplicateConnections"`
+	Timestamps           TimestampConfig      `yaml:"timestamps" json:"timestamps"`
+	HistoryRetention     time.Duration        `yaml:"historyRetention" json:"historyRetention"`
+	DangerFloor          DangerFloorConfig    `yaml:"dangerFloor" json:"dangerFloor"`
+	HideIdleNodes        bool                 `yaml:"hideIdleNodes" json:"hideIdleNodes"`
+	SustainedDanger      int                  `yaml:"sustainedDanger" json:"sustainedDanger"`
+	Anonymize            bool                 `yaml:"anonymize" json:"anonymize"`
+	ReloadInterval       time.Duration        `yaml:"reloadInterval" json:"reloadInterval"`
+	Aggregate            AggregateConfig      `yaml:"aggregate" json:"aggregate"`
+	PrettyJSON           bool                 `yaml:"prettyJSON" json:"prettyJSON"`
+	Warmup               time.Duration        `yaml:"warmup" json:"warmup"`
+	MaxDimensions        int                  `yaml:"maxDimensions" json:"maxDimensions"`
+	RendererCompat       []RendererCompat     `yaml:"rendererCompat" json:"rendererCompat"`
+	Clusters             bool                 `yaml:"clusters" json:"clusters"`
+	FlushOnShutdown      bool                 `yaml:"flushOnShutdown" json:"flushOnShutdown"`
+	HMAC                 HMACConfig           `yaml:"hmac" json:"hmac"`
+	WarningAsDanger      bool                 `yaml:"warningAsDanger" json:"warningAsDanger"`
+	MaxInFlight          int                  `yaml:"maxInFlight" json:"maxInFlight"`
+	AlignSnapshots       bool                 `yaml:"alignSnapshots" json:"alignSnapshots"`
+	KeySeparator         string               `yaml:"keySeparator" json:"keySeparator"`
+	NormalizeReplicas    bool                 `yaml:"normalizeReplicas" json:"normalizeReplicas"`
+	PagerDuty            PagerDutyConfig      `yaml:"pagerduty" json:"pagerduty"`
+	Rates                bool                 `yaml:"rates" json:"rates"`
+	MaxConnections       int                  `yaml:"maxConnections" json:"maxConnections"`
+	AsyncLog             bool                 `yaml:"asyncLog" json:"asyncLog"`
+	FailureTypes         []string             `yaml:"failureTypes" json:"failureTypes"`
+	DecayHalfLife        time.Duration        `yaml:"decayHalfLife" json:"decayHalfLife"`
+	MaxResponseBytes     int                  `yaml:"maxResponseBytes" json:"maxResponseBytes"`
+	NoticeHold           time.Duration        `yaml:"noticeHold" json:"noticeHold"`
+	Envoy                EnvoyConfig          `yaml:"envoy" json:"envoy"`
+	StatusCodes          StatusConfig         `yaml:"statusCodes" json:"statusCodes"`
+	PreviewFirstWindow   bool                 `yaml:"previewFirstWindow" json:"previewFirstWindow"`
+	StrictConnections    bool                 `yaml:"strictConnections" json:"strictConnections"`
+	MinEdgeVolume        int                  `yaml:"minEdgeVolume" json:"minEdgeVolume"`
+	BasePath             string               `yaml:"basePath" json:"basePath"`
+	Dump                 DumpConfig           `yaml:"dump" json:"dump"`
+	Deadman              time.Duration        `yaml:"deadman" json:"deadman"`
+	ExpectedVolume       ExpectedVolumeConfig `yaml:"expectedVolume" json:"expectedVolume"`
+	Readiness            string               `yaml:"readiness" json:"readiness"`
+	MaxNotices           int                  `yaml:"maxNotices" json:"maxNotices"`
+	Classification       string               `yaml:"classification" json:"classification"`
+	Discovery            DiscoveryConfig      `yaml:"discovery" json:"discovery"`
+	MetricsFormat        string               `yaml:"metricsFormat" json:"metricsFormat"`
+	LabelTemplate        string               `yaml:"labelTemplate" json:"labelTemplate"`
+	NodeSizing           NodeSizingConfig     `yaml:"nodeSizing" json:"nodeSizing"`
+	ColorBy              ColorByConfig        `yaml:"colorBy" json:"colorBy"`
+	Availability         AvailabilityConfig   `yaml:"availability" json:"availability"`
+	LatencyHistogram     HistogramConfig      `yaml:"latencyHistogram" json:"latencyHistogram"`
+	KeepPorts            bool                 `yaml:"keepPorts" json:"keepPorts"`
+	HotLocks             HotLocksConfig       `yaml:"hotLocks" json:"hotLocks"`
+	Settling             time.Duration        `yaml:"settling" json:"settling"`
+	Sequence             bool                 `yaml:"sequence" json:"sequence"`
+	Saturation           SaturationConfig     `yaml:"saturation" json:"saturation"`
+
+	// Loaded describes the files the config was read from, it is
+	// only shown by /config and cannot be set
+	Loaded []LoadedFile `yaml:"-" json:"loaded,omitempty"`
+
+	// path is the file the config was read from
+	path string
+}
+
+func (c Config) keySeparator() string {
+	if c.KeySeparator == "" {
+		return ":"
+	}
+	return c.KeySeparator
+}
+
+func (c Config) maxKeyLength() int {
+	if c.MaxKeyLength <= 0 {
+		return 256
+	}
+	return c.MaxKeyLength
+}
+
+// buckets returns the configured buckets
+func (c Config) buckets() []string {
+	if len(c.Buckets) == 0 {
+		return defaultBuckets
+	}
+	return c.Buckets
+}
+
+// defaultFailureTypes are the failure types used when none are configured
+var defaultFailureTypes = []string{"timeout", "refused", "error"}
+
+func (c Config) hasFailureType(failure string) bool {
+	types := c.FailureTypes
+	if len(types) == 0 {
+		types = defaultFailureTypes
+	}
+	for _, t := range types {
+		if t == failure {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) hasBucket(bucket string) bool {
+	for _, b := range c.buckets() {
+		if b == bucket {
+			return true
+		}
+	}
+	return false
+}
+
+func (c Config) staleAfter() time.Duration {
+	if c.StaleAfter <= 0 {
+		return 2 * snapshotInterval
+	}
+	return c.StaleAfter
+}
+
+// LoadConfig reads the config from conf.yaml, falling back
+// to /etc/cargo/conf.yaml, and merges the overlay file over it
+// when one is given, see mergeOverlay
+func LoadConfig(overlay string) Config {
+	var c Config
+	c.getConfig(overlay)
+	return c
+}
+
+// LoadedFile identifies a config file as it was loaded, to check
+// which version of it is live
+type LoadedFile struct {
+	Path    string    `json:"path"`
+	ModTime time.Time `json:"modTime"`
+	SHA256  string    `json:"sha256"`
+}
+
+func loadedFile(path string, content []byte) LoadedFile {
+	sum := sha256.Sum256(content)
+	f := LoadedFile{Path: path, SHA256: hex.EncodeToString(sum[:])}
+	if info, err := os.Stat(path); err == nil {
+		f.ModTime = info.ModTime()
+	}
+	return f
+}
+
+// Path returns the file the config was loaded from, empty when none was found
+func (c Config) Path() string {
+	return c.path
+}
+
+func (c *Config) getConfig(overlay string) *Config {
+
+	c.path = "conf.yaml"
+	yamlFile, err := ioutil.ReadFile(c.path)
+	if err != nil {
+		log.Printf("error opening #%v ", err)
+		c.path = "/etc/cargo/conf.yaml"
+		yamlFile, err = ioutil.ReadFile(c.path)
+		if err != nil {
+			log.Printf("error opening #%v ", err)
+			c.path = ""
+		}
+	}
+	err = yaml.Unmarshal(yamlFile, c)
+	if err != nil {
+		log.Fatalf("Unmarshal: %v", err)
+	}
+	if c.path != "" {
+		c.Loaded = append(c.Loaded, loadedFile(c.path, yamlFile))
+	}
+
+	fmt.Printf("Initialized with config = \n\n%s\n\n", yamlFile)
+	if overlay != "" {
+		overlayFile, err := ioutil.ReadFile(overlay)
+		if err != nil {
+			log.Fatalf("error opening overlay #%v ", err)
+		}
+		if err := c.mergeOverlay(overlayFile); err != nil {
+			log.Fatalf("Unmarshal overlay: %v", err)
+		}
+		c.Loaded = append(c.Loaded, loadedFile(overlay, overlayFile))
+		log.Printf("merged overlay %s", overlay)
+	}
+	return c
+}
+
+// mergeOverlay applies an overlay config over c. Settings other than
+// ships are decoded over the primary ones, so only the keys the overlay
+// sets change, nested ones included. Ships are merged tier by tier:
+// new tiers are added, and for existing ones replicas, clients,
+// servers, group, type, services, slo and capacity are replaced when
+// the overlay sets them while connections are merged by host, the
+// overlay winning
+func (c *Config) mergeOverlay(data []byte) error {
+	ships := c.Ships
+	c.Ships = nil
+	if err := yaml.Unmarshal(data, c); err != nil {
+		c.Ships = ships
+		return err
+	}
+	overlay := c.Ships
+	c.Ships = ships
+	if c.Ships == nil && len(overlay) > 0 {
+		c.Ships = make(map[string]Ship, len(overlay))
+	}
+	for name, ship := range overlay {
+		if base, ok := c.Ships[name]; ok {
+			ship = base.merge(ship)
+		}
+		c.Ships[name] = ship
+	}
+	return nil
+}
+
+// merge returns s with the settings of overlay applied, see mergeOverlay
+func (s Ship) merge(overlay Ship) Ship {
+	if overlay.Replicas != 0 {
+		s.Replicas = overlay.Replicas
+	}
+	if overlay.Clients != nil {
+		s.Clients = overlay.Clients
+	}
+	if overlay.Servers != nil {
+		s.Servers = overlay.Servers
+	}
+	if overlay.Group != "" {
+		s.Group = overlay.Group
+	}
+	if overlay.Type != "" {
+		s.Type = overlay.Type
+	}
+	if overlay.Services != nil {
+		s.Services = overlay.Services
+	}
+	if overlay.SLO != 0 {
+		s.SLO = overlay.SLO
+	}
+	if overlay.Capacity != 0 {
+		s.Capacity = overlay.Capacity
+	}
+	if len(overlay.Connections) > 0 {
+		connections := make(map[string]ConnectionConfig, len(s.Connections)+len(overlay.Connections))
+		for host, conf := range s.Connections {
+			connections[host] = conf
+		}
+		for host, conf := range overlay.Connections {
+			connections[host] = conf
+		}
+		s.Connections = connections
+	}
+	return s
+}
@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"reflect"
+	"testing"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+const basePrimary = `
+ships:
+  web:
+    replicas: 2
+    clients: ["10.0.0.1:80"]
+    servers: [80]
+    connections:
+      10.0.0.1:
+        suppressAlerts: true
+  db:
+    replicas: 1
+    clients: ["10.0.1.1:5432"]
+thresholds:
+  warning: 0.01
+  danger: 0.05
+`
+
+const envOverlay = `
+ships:
+  web:
+    replicas: 5
+    connections:
+      10.0.0.2:
+        forceNormal: true
+  cache:
+    replicas: 3
+    clients: ["10.0.2.1:6379"]
+thresholds:
+  danger: 0.1
+`
+
+func TestMergeOverlay(t *testing.T) {
+	var c Config
+	if err := yaml.Unmarshal([]byte(basePrimary), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.mergeOverlay([]byte(envOverlay)); err != nil {
+		t.Fatal(err)
+	}
+
+	web := Ship{
+		Replicas: 5,
+		Clients:  []string{"10.0.0.1:80"},
+		Servers:  []int{80},
+		Connections: map[string]ConnectionConfig{
+			"10.0.0.1": {SuppressAlerts: true},
+			"10.0.0.2": {ForceNormal: true},
+		},
+	}
+	if !reflect.DeepEqual(c.Ships["web"], web) {
+		t.Errorf("web = %+v, want %+v", c.Ships["web"], web)
+	}
+	db := Ship{Replicas: 1, Clients: []string{"10.0.1.1:5432"}}
+	if !reflect.DeepEqual(c.Ships["db"], db) {
+		t.Errorf("db = %+v, want it unchanged %+v", c.Ships["db"], db)
+	}
+	cache := Ship{Replicas: 3, Clients: []string{"10.0.2.1:6379"}}
+	if !reflect.DeepEqual(c.Ships["cache"], cache) {
+		t.Errorf("cache = %+v, want it added %+v", c.Ships["cache"], cache)
+	}
+	thresholds := Thresholds{Warning: 0.01, Danger: 0.1}
+	if c.Thresholds != thresholds {
+		t.Errorf("thresholds = %+v, want %+v", c.Thresholds, thresholds)
+	}
+}
+
+func TestMergeOverlayReplacesClients(t *testing.T) {
+	var c Config
+	if err := yaml.Unmarshal([]byte(basePrimary), &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := c.mergeOverlay([]byte("ships:\n  db:\n    clients: [\"10.0.1.2:5432\"]\n")); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"10.0.1.2:5432"}
+	if got := c.Ships["db"].Clients; !reflect.DeepEqual(got, want) {
+		t.Errorf("db clients = %v, want %v", got, want)
+	}
+	if len(c.Ships) != 2 {
+		t.Errorf("got %d ships, want 2", len(c.Ships))
+	}
+}
@@ -0,0 +1,54 @@
+package collector
+
+import (
+	"math"
+	"time"
+)
+
+// decayed holds the exponentially weighted moving average of the
+// windows of a connection, kept as floats so small buckets do not
+// round away between snapshots
+type decayed struct {
+	normal, danger, warning float64
+	custom                  map[string]float64
+}
+
+// decayFactor returns the weight the previous average keeps after one
+// snapshot interval for the given half life
+func decayFactor(halfLife time.Duration) float64 {
+	return math.Pow(0.5, float64(snapshotInterval)/float64(halfLife))
+}
+
+// add folds a completed window into the average, weighting the
+// previous average by f, and returns the rounded result
+func (d *decayed) add(window Metrics, f float64) Metrics {
+	ewma := func(prev float64, n int) float64 {
+		return f*prev + (1-f)*float64(n)
+	}
+	d.normal = ewma(d.normal, window.Normal)
+	d.danger = ewma(d.danger, window.Danger)
+	d.warning = ewma(d.warning, window.Warning)
+	for name := range d.custom {
+		if _, ok := window.Custom[name]; !ok {
+			d.custom[name] = ewma(d.custom[name], 0)
+		}
+	}
+	for name, n := range window.Custom {
+		if d.custom == nil {
+			d.custom = make(map[string]float64)
+		}
+		d.custom[name] = ewma(d.custom[name], n)
+	}
+
+	m := Metrics{
+		Normal:  int(math.Round(d.normal)),
+		Danger:  int(math.Round(d.danger)),
+		Warning: int(math.Round(d.warning)),
+	}
+	for name, n := range d.custom {
+		if rounded := int(math.Round(n)); rounded > 0 {
+			m.addBucket(name, rounded)
+		}
+	}
+	return m
+}
@@ -0,0 +1,64 @@
+package collector
+
+// otherDimension holds the metrics of the labels logged after a
+// connection reached the dimension limit in a window
+const otherDimension = "other"
+
+// Dimensions holds the metrics of a connection split by an optional
+// label such as the route or method of the logged requests
+type Dimensions map[string]Metrics
+
+// add records m under label, or under otherDimension once max
+// labels are already tracked
+func (d *Dimensions) add(label string, m Metrics, max int) {
+	if *d == nil {
+		*d = make(Dimensions)
+	}
+	if _, ok := (*d)[label]; !ok && len(*d) >= max {
+		label = otherDimension
+	}
+	sum := (*d)[label]
+	sum.Add(m)
+	(*d)[label] = sum
+}
+
+// Clusters are bounded as a safeguard against clients that log a
+// different cluster on every request
+const (
+	defaultCluster = "default"
+	maxClusters    = 64
+)
+
+// addCluster records m under the cluster that logged it, unlabeled
+// metrics are recorded under defaultCluster
+func (d *Dimensions) addCluster(cluster string, m Metrics) {
+	if cluster == "" {
+		cluster = defaultCluster
+	}
+	d.add(cluster, m, maxClusters)
+}
+
+// merge adds the metrics of o into a copy of d
+func (d Dimensions) merge(o Dimensions) Dimensions {
+	if len(o) == 0 {
+		return d
+	}
+	merged := make(Dimensions, len(d)+len(o))
+	for _, src := range []Dimensions{d, o} {
+		for label, m := range src {
+			sum := merged[label]
+			sum.Add(m)
+			merged[label] = sum
+		}
+	}
+	return merged
+}
+
+// maxDimensions returns the number of labels tracked per connection
+// and window, defaulting to 10
+func (c Config) maxDimensions() int {
+	if c.MaxDimensions <= 0 {
+		return 10
+	}
+	return c.MaxDimensions
+}
@@ -0,0 +1,192 @@
+package collector
+
+import (
+	"bufio"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DiscoveryConfig builds connections from the TCP connections of the
+// host, read from /proc/net/tcp and /proc/net/tcp6 every Interval, for
+// single host setups without a static topology. Ports maps the ports
+// known processes listen on to their tier. An established connection
+// to one of them is a connection to the tier of the remote port, from
+// the tier of the ports the process owning the socket listens on. When
+// that process listens on none of them, or can't be read from
+// /proc/[pid]/fd without the privileges to, the source is Source,
+// defaulting to the hostname. Discovered connections are created
+// alongside the configured ones, unless StrictConnections is set
+// Backend seeds the topology at startup from a service discovery
+// backend instead, currently only consul, see ConsulConfig. Its tiers
+// are created as if they were configured ships
+type DiscoveryConfig struct {
+	Interval time.Duration  `yaml:"interval" json:"interval"`
+	Ports    map[int]string `yaml:"ports" json:"ports"`
+	Source   string         `yaml:"source" json:"source"`
+	Backend  string         `yaml:"backend" json:"backend"`
+	Consul   ConsulConfig   `yaml:"consul" json:"consul"`
+}
+
+func (c DiscoveryConfig) source() string {
+	if c.Source != "" {
+		return c.Source
+	}
+	if hostname, err := os.Hostname(); err == nil {
+		return hostname
+	}
+	return "localhost"
+}
+
+// procNetFiles are the socket tables connections are discovered from
+var procNetFiles = []string{"/proc/net/tcp", "/proc/net/tcp6"}
+
+// procDir is where the file descriptors of processes are read from
+var procDir = "/proc"
+
+// States of the sockets in /proc/net/tcp
+const (
+	tcpEstablished = "01"
+	tcpListen      = "0A"
+)
+
+// socket is an entry of a /proc/net/tcp table
+type socket struct {
+	local, remote int
+	state         string
+	inode         string
+}
+
+// discoveryLoop creates the connections found every Discovery.Interval
+func (v *Vizceral) discoveryLoop() {
+	conf := v.config.Discovery
+	source := conf.source()
+	log.Printf("discovering connections every %s", conf.Interval)
+	for ; ; time.Sleep(conf.Interval) {
+		var sockets []socket
+		for _, path := range procNetFiles {
+			f, err := os.Open(path)
+			if err != nil {
+				continue
+			}
+			sockets = append(sockets, readSockets(f)...)
+			f.Close()
+		}
+		tiers := listeningTiers(sockets, socketOwners(procDir), conf.Ports)
+		found := make(map[string]bool)
+		for _, s := range sockets {
+			if s.state != tcpEstablished {
+				continue
+			}
+			target, ok := conf.Ports[s.remote]
+			if !ok {
+				continue
+			}
+			from, ok := tiers[s.inode]
+			if !ok {
+				from = source
+			}
+			if from != target {
+				found[v.key(from, target)] = true
+			}
+		}
+		for connection := range found {
+			v.graphMu.RLock()
+			_, ok := v.ConnectionMap.connections[connection]
+			v.graphMu.RUnlock()
+			if !ok {
+				v.createConnection(connection, &v.discovered)
+			}
+		}
+	}
+}
+
+// listeningTiers returns the tier of the process owning each socket,
+// that of the lowest known port the process listens on
+func listeningTiers(sockets []socket, owners map[string]int, ports map[int]string) map[string]string {
+	listening := make(map[int]int)
+	for _, s := range sockets {
+		if s.state != tcpListen {
+			continue
+		}
+		pid, ok := owners[s.inode]
+		if _, known := ports[s.local]; !ok || !known {
+			continue
+		}
+		if port, ok := listening[pid]; !ok || s.local < port {
+			listening[pid] = s.local
+		}
+	}
+	tiers := make(map[string]string)
+	for inode, pid := range owners {
+		if port, ok := listening[pid]; ok {
+			tiers[inode] = ports[port]
+		}
+	}
+	return tiers
+}
+
+// socketOwners returns the pid of the process holding each socket
+// inode, read from the file descriptors under a /proc directory.
+// Processes that can't be read are left out
+func socketOwners(proc string) map[string]int {
+	owners := make(map[string]int)
+	dirs, err := ioutil.ReadDir(proc)
+	if err != nil {
+		return owners
+	}
+	for _, dir := range dirs {
+		pid, err := strconv.Atoi(dir.Name())
+		if err != nil {
+			continue
+		}
+		fds, err := ioutil.ReadDir(filepath.Join(proc, dir.Name(), "fd"))
+		if err != nil {
+			continue
+		}
+		for _, fd := range fds {
+			link, err := os.Readlink(filepath.Join(proc, dir.Name(), "fd", fd.Name()))
+			if err != nil || !strings.HasPrefix(link, "socket:[") {
+				continue
+			}
+			owners[strings.TrimSuffix(strings.TrimPrefix(link, "socket:["), "]")] = pid
+		}
+	}
+	return owners
+}
+
+// readSockets returns the listening and established sockets listed in
+// a /proc/net/tcp table
+func readSockets(table io.Reader) []socket {
+	var sockets []socket
+	scanner := bufio.NewScanner(table)
+	scanner.Scan() // header
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 10 || (fields[3] != tcpEstablished && fields[3] != tcpListen) {
+			continue
+		}
+		local, err := socketPort(fields[1])
+		if err != nil {
+			continue
+		}
+		remote, err := socketPort(fields[2])
+		if err != nil {
+			continue
+		}
+		sockets = append(sockets, socket{local: local, remote: remote, state: fields[3], inode: fields[9]})
+	}
+	return sockets
+}
+
+// socketPort returns the port of an address such as 0100007F:1F90
+func socketPort(address string) (int, error) {
+	i := strings.LastIndexByte(address, ':')
+	port, err := strconv.ParseUint(address[i+1:], 16, 16)
+	return int(port), err
+}
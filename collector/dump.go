@@ -0,0 +1,98 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// DumpConfig periodically records the graph as served by /get, so
+// there is a record of it for later analysis even when nobody polled
+// Interval enables it. With Path the graph is appended to that file
+// as one JSON line per dump, otherwise it is written to the log. The
+// file is rotated to Path.1 and so on once it exceeds MaxBytes,
+// defaulting to 10MB, keeping Keep old files, defaulting to 3
+type DumpConfig struct {
+	Interval time.Duration `yaml:"interval" json:"interval"`
+	Path     string        `yaml:"path" json:"path"`
+	MaxBytes int64         `yaml:"maxBytes" json:"maxBytes"`
+	Keep     int           `yaml:"keep" json:"keep"`
+}
+
+func (c DumpConfig) maxBytes() int64 {
+	if c.MaxBytes <= 0 {
+		return 10 << 20
+	}
+	return c.MaxBytes
+}
+
+func (c DumpConfig) keep() int {
+	if c.Keep <= 0 {
+		return 3
+	}
+	return c.Keep
+}
+
+// dumpLoop records the graph every Dump.Interval
+func (v *Vizceral) dumpLoop() {
+	conf := v.config.Dump
+	log.Printf("dumping the graph every %s to %s", conf.Interval, conf.destination())
+	for range time.Tick(conf.Interval) {
+		v.graphMu.RLock()
+		data, err := json.Marshal(v.refreshed().output())
+		v.graphMu.RUnlock()
+		if err != nil {
+			log.Printf("failed to convert graph into JSON: %v", err)
+			continue
+		}
+		if conf.Path == "" {
+			log.Printf("graph: %s", data)
+			continue
+		}
+		if err := conf.write(append(data, '\n')); err != nil {
+			log.Printf("failed to dump the graph: %v", err)
+		}
+	}
+}
+
+func (c DumpConfig) destination() string {
+	if c.Path == "" {
+		return "the log"
+	}
+	return c.Path
+}
+
+// write appends data to the dump file, rotating it first when full
+func (c DumpConfig) write(data []byte) error {
+	if info, err := os.Stat(c.Path); err == nil && info.Size()+int64(len(data)) > c.maxBytes() {
+		if err := c.rotate(); err != nil {
+			return err
+		}
+	}
+	f, err := os.OpenFile(c.Path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+// rotate shifts Path.n to Path.n+1, dropping the oldest, and moves
+// the current file to Path.1
+func (c DumpConfig) rotate() error {
+	os.Remove(fmt.Sprintf("%s.%d", c.Path, c.keep()))
+	for n := c.keep() - 1; n >= 1; n-- {
+		from := fmt.Sprintf("%s.%d", c.Path, n)
+		if _, err := os.Stat(from); err == nil {
+			if err := os.Rename(from, fmt.Sprintf("%s.%d", c.Path, n+1)); err != nil {
+				return err
+			}
+		}
+	}
+	return os.Rename(c.Path, c.Path+".1")
+}
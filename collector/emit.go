@@ -0,0 +1,55 @@
+package collector
+
+import (
+	"math"
+	"sync"
+)
+
+// emitFilter lets an integration skip a connection whose volume has
+// changed by no more than minChange percent since it last emitted
+// for it, cutting the noise of near constant connections
+type emitFilter struct {
+	minChange float64
+
+	mu   sync.Mutex
+	last map[string]int
+}
+
+func newEmitFilter(minChange float64) *emitFilter {
+	return &emitFilter{minChange: minChange, last: make(map[string]int)}
+}
+
+// allow reports whether to emit for the connection with the given key
+// and volume, remembering the volume when it does. The first emission
+// of a connection is always allowed
+func (f *emitFilter) allow(key string, volume int) bool {
+	if f == nil || f.minChange <= 0 {
+		return true
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	last, ok := f.last[key]
+	if ok {
+		change := math.Inf(1)
+		if last != 0 {
+			change = math.Abs(float64(volume-last)) / float64(last) * 100
+		} else if volume == 0 {
+			change = 0
+		}
+		if change <= f.minChange {
+			return false
+		}
+	}
+	f.last[key] = volume
+	return true
+}
+
+// forget drops the volume remembered for a connection that was removed
+func (f *emitFilter) forget(key string) {
+	if f == nil {
+		return
+	}
+	f.mu.Lock()
+	delete(f.last, key)
+	f.mu.Unlock()
+}
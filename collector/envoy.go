@@ -0,0 +1,154 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// EnvoyConfig maps the fields of Envoy JSON access log entries posted
+// to /log/envoy onto connections. Source holds the tier logging the
+// request, defaulting to source, Target the upstream it was sent to,
+// defaulting to upstream_cluster, and Status the response code,
+// defaulting to response_code. Nested fields are reached with dots,
+// such as labels.app. A target given as host:port is logged by host
+// unless KeepPorts is set
+type EnvoyConfig struct {
+	Source string `yaml:"source" json:"source"`
+	Target string `yaml:"target" json:"target"`
+	Status string `yaml:"status" json:"status"`
+}
+
+func (c EnvoyConfig) source() string {
+	if c.Source == "" {
+		return "source"
+	}
+	return c.Source
+}
+
+func (c EnvoyConfig) target() string {
+	if c.Target == "" {
+		return "upstream_cluster"
+	}
+	return c.Target
+}
+
+func (c EnvoyConfig) status() string {
+	if c.Status == "" {
+		return "response_code"
+	}
+	return c.Status
+}
+
+// envoyField returns the value of a dotted field of an entry as a
+// string, and whether it was found
+func envoyField(entry map[string]interface{}, field string) (string, bool) {
+	var value interface{} = entry
+	for _, name := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", false
+		}
+		if value, ok = object[name]; !ok {
+			return "", false
+		}
+	}
+	switch value := value.(type) {
+	case string:
+		return value, value != ""
+	case float64:
+		return strconv.FormatFloat(value, 'f', -1, 64), true
+	}
+	return "", false
+}
+
+// decodeEnvoy reads the access log entries of a body holding either
+// a JSON array of them or one entry per line, as Envoy writes them
+func decodeEnvoy(body []byte) ([]map[string]interface{}, error) {
+	var entries []map[string]interface{}
+	if trimmed := bytes.TrimSpace(body); len(trimmed) > 0 && trimmed[0] == '[' {
+		err := json.Unmarshal(trimmed, &entries)
+		return entries, err
+	}
+	decoder := json.NewDecoder(bytes.NewReader(body))
+	for decoder.More() {
+		var entry map[string]interface{}
+		if err := decoder.Decode(&entry); err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+	return entries, nil
+}
+
+// logEnvoy logs a batch of Envoy access log entries, counting each
+// in the bucket of its response code, see Config.StatusCodes. Entries
+// missing a mapped field are skipped. It responds with the number of
+// entries logged and skipped and the connection keys rejected
+func (v *Vizceral) logEnvoy(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if v.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 - draining"))
+		return
+	}
+	body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxLogBody))
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - failed to read the body"))
+		return
+	}
+	entries, err := decodeEnvoy(body)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - body must hold JSON access log entries"))
+		return
+	}
+	conf := v.config.Envoy
+	batch := make(map[string]Metrics)
+	counts := make(map[string]int)
+	skipped := 0
+	for _, entry := range entries {
+		source, ok := envoyField(entry, conf.source())
+		target, ok2 := envoyField(entry, conf.target())
+		status, ok3 := envoyField(entry, conf.status())
+		code, err := strconv.Atoi(status)
+		if !ok || !ok2 || !ok3 || err != nil {
+			skipped++
+			continue
+		}
+		if host, _, err := net.SplitHostPort(target); err == nil && !v.config.KeepPorts {
+			target = host
+		}
+		connection := v.key(source, target)
+		if len(connection) > v.config.maxKeyLength() {
+			skipped++
+			continue
+		}
+		m := batch[connection]
+		m.addBucket(v.config.StatusCodes.bucketOf(code), observationWeight)
+		batch[connection] = m
+		counts[connection]++
+	}
+	rejected := v.logBatch(batch, v.proxies.clientIP(r))
+	if rejected == nil {
+		rejected = []string{}
+	}
+	logged := len(entries) - skipped
+	for _, connection := range rejected {
+		logged -= counts[connection]
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"logged":   logged,
+		"skipped":  skipped,
+		"rejected": rejected,
+	})
+}
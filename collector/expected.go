@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"fmt"
+	"math"
+)
+
+// ExpectedVolumeConfig flags connections whose traffic strays from
+// the ExpectedRate of their ConnectionConfig, catching partial outages
+// that leave the error rate untouched. A connection whose requests per
+// second differ from the expected rate by more than Deviation percent,
+// defaulting to 50, is given a notice, and with Warning is shown in
+// warning unless its class is already worse
+type ExpectedVolumeConfig struct {
+	Deviation float64 `yaml:"deviation" json:"deviation"`
+	Warning   bool    `yaml:"warning" json:"warning"`
+}
+
+func (c ExpectedVolumeConfig) deviation() float64 {
+	if c.Deviation <= 0 {
+		return 50
+	}
+	return c.Deviation
+}
+
+// volumeDeviation returns the notice of a connection whose rate strays
+// from its expected rate, nil when it has none or is within bounds
+func (v *Vizceral) volumeDeviation(con *VizceralConnection) *Notice {
+	if con.expectedRate <= 0 {
+		return nil
+	}
+	rate := float64(con.Metrics.observations()) / con.window().Seconds()
+	deviation := 100 * math.Abs(rate-con.expectedRate) / con.expectedRate
+	if deviation <= v.config.ExpectedVolume.deviation() {
+		return nil
+	}
+	direction := "below"
+	if rate > con.expectedRate {
+		direction = "above"
+	}
+	notice := &Notice{Title: fmt.Sprintf("%.1f req/s is %.0f%% %s the expected %g req/s", rate, deviation, direction, con.expectedRate)}
+	if v.config.ExpectedVolume.Warning {
+		notice.Severity = noticeWarning
+	}
+	return notice
+}
@@ -0,0 +1,139 @@
+package collector
+
+import (
+	"encoding/csv"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+
+	yaml "gopkg.in/yaml.v2"
+)
+
+// exportCSV writes one row per connection with its committed metrics
+func (v *Vizceral) exportCSV(w http.ResponseWriter, r *http.Request) {
+	v.graphMu.RLock()
+	defer v.graphMu.RUnlock()
+
+	keys := make([]string, 0, len(v.ConnectionMap.connections))
+	for key := range v.ConnectionMap.connections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	w.Header().Set("Content-Type", "text/csv")
+	w.Header().Set("Content-Disposition", `attachment; filename="cargo-connections.csv"`)
+	out := csv.NewWriter(w)
+	out.Write([]string{"source", "target", "normal", "warning", "danger", "total", "class"})
+	for _, key := range keys {
+		con := v.ConnectionMap.connections[key]
+		con.mu.Lock()
+		m := con.Metrics
+		con.mu.Unlock()
+		out.Write([]string{
+			con.Source,
+			con.Target,
+			strconv.Itoa(m.Normal),
+			strconv.Itoa(m.Warning),
+			strconv.Itoa(m.Danger),
+			strconv.Itoa(m.Sum()),
+			con.Class,
+		})
+	}
+	out.Flush()
+}
+
+// topology is the part of the config describing the graph, as
+// written by /export/config
+type topology struct {
+	Ships            map[string]Ship `yaml:"ships"`
+	Entry            *EntryConfig    `yaml:"entry,omitempty"`
+	Loopback         bool            `yaml:"loopback,omitempty"`
+	PlaceholderNodes bool            `yaml:"placeholderNodes,omitempty"`
+	KeySeparator     string          `yaml:"keySeparator,omitempty"`
+	KeepPorts        bool            `yaml:"keepPorts,omitempty"`
+}
+
+// exportConfig writes the current nodes and connections as a config
+// file that recreates them, including the ones created at runtime.
+// Every node with outgoing connections becomes a tier whose clients
+// are its targets. Connection keys drop the port of a client, the
+// configured ones of the tier are kept and created clients are given
+// port 0, unless KeepPorts keeps it in their target
+func (v *Vizceral) exportConfig(w http.ResponseWriter, r *http.Request) {
+	v.graphMu.RLock()
+	defer v.graphMu.RUnlock()
+
+	out := topology{
+		Ships:        make(map[string]Ship),
+		Loopback:     v.config.Loopback,
+		KeySeparator: v.config.KeySeparator,
+		KeepPorts:    v.config.KeepPorts,
+	}
+	if v.EntryNode != "" {
+		entry := v.config.Entry
+		entry.Targets = nil
+		out.Entry = &entry
+	}
+	for name, ship := range v.config.Ships {
+		ship.Clients = nil
+		out.Ships[name] = ship
+	}
+	// the configured clients of each tier by host, a host can be
+	// reached on several ports, by one tier or several
+	ports := make(map[[2]string][]string)
+	for name, ship := range v.config.Ships {
+		for _, client := range ship.Clients {
+			if host, _, err := net.SplitHostPort(client); err == nil {
+				ports[[2]string{name, host}] = append(ports[[2]string{name, host}], client)
+			}
+		}
+	}
+
+	keys := make([]string, 0, len(v.ConnectionMap.connections))
+	for key := range v.ConnectionMap.connections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	for _, key := range keys {
+		con := v.ConnectionMap.connections[key]
+		switch con.Source {
+		case v.EntryNode:
+			out.Entry.Targets = append(out.Entry.Targets, con.Target)
+			continue
+		case loopbackName:
+			if v.config.Loopback {
+				continue
+			}
+		}
+		if v.isOrigin(con.Source) {
+			continue
+		}
+		clients, ok := ports[[2]string{con.Source, con.Target}]
+		if _, _, err := net.SplitHostPort(con.Target); err == nil && v.config.KeepPorts {
+			clients, ok = []string{con.Target}, true
+		}
+		if !ok {
+			clients = []string{net.JoinHostPort(con.Target, "0")}
+		}
+		ship := out.Ships[con.Source]
+		ship.Clients = append(ship.Clients, clients...)
+		out.Ships[con.Source] = ship
+	}
+	// targets that are not tiers are recreated as placeholders
+	for _, con := range v.ConnectionMap.connections {
+		if _, tier := out.Ships[con.Target]; !tier && con.Target != loopbackName {
+			out.PlaceholderNodes = true
+		}
+	}
+
+	data, err := yaml.Marshal(out)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert topology into YAML"))
+		return
+	}
+	w.Header().Set("Content-Type", "application/x-yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="conf.yaml"`)
+	w.Write(data)
+}
@@ -0,0 +1,71 @@
+package collector
+
+import (
+	"fmt"
+	"log"
+	"reflect"
+
+	"github.com/expr-lang/expr"
+	"github.com/expr-lang/expr/vm"
+)
+
+// classEnv holds the fields of a connection a Classification
+// expression can refer to. The counts are of logged requests and the
+// latency percentiles are 0 when no latency was reported
+type classEnv struct {
+	Source    string  `expr:"source"`
+	Target    string  `expr:"target"`
+	Normal    int     `expr:"normal"`
+	Warning   int     `expr:"warning"`
+	Danger    int     `expr:"danger"`
+	Volume    int     `expr:"volume"`
+	ErrorRate float64 `expr:"errorRate"`
+	WarnRate  float64 `expr:"warnRate"`
+	P50       float64 `expr:"p50"`
+	P95       float64 `expr:"p95"`
+	P99       float64 `expr:"p99"`
+}
+
+// compileClassification compiles a Classification expression, nil
+// when it is empty
+func compileClassification(source string) (*vm.Program, error) {
+	if source == "" {
+		return nil, nil
+	}
+	return expr.Compile(source, expr.Env(classEnv{}), expr.AsKind(reflect.String))
+}
+
+// expressionClass evaluates the Classification expression against the
+// metrics a connection is classified by. A result that is not a class
+// is logged and the connection left unclassified
+func (v *Vizceral) expressionClass(con *VizceralConnection, m Metrics) string {
+	out, err := expr.Run(v.classification, v.classEnv(con, m))
+	if err == nil {
+		switch class := out.(string); class {
+		case "", classNormal, classWarning, classDanger:
+			return class
+		default:
+			err = fmt.Errorf("%q is not a class", class)
+		}
+	}
+	log.Printf("warning: classification of %s failed: %v", v.key(con.Source, con.Target), err)
+	return ""
+}
+
+// classEnv returns the fields of a connection classified by m
+func (v *Vizceral) classEnv(con *VizceralConnection, m Metrics) classEnv {
+	env := classEnv{
+		Source:    con.Source,
+		Target:    con.Target,
+		Normal:    m.Normal / observationWeight,
+		Warning:   m.Warning / observationWeight,
+		Danger:    m.Danger / observationWeight,
+		Volume:    m.observations(),
+		ErrorRate: m.errorRate(),
+		WarnRate:  m.warnRate(),
+	}
+	if l := con.Latency; l != nil {
+		env.P50, env.P95, env.P99 = l.P50, l.P95, l.P99
+	}
+	return env
+}
@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+)
+
+// Graphs holds several collectors keyed by graph name so that
+// they can be fetched together in one time aligned request
+type Graphs map[string]*Vizceral
+
+// RegisterHandlers adds the /get/all endpoint to mux
+func (g Graphs) RegisterHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/get/all", g.getAll)
+}
+
+func (g Graphs) getAll(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	// each graph is converted while it is locked, so no graph is held
+	// while the others are
+	graphs := make(map[string]json.RawMessage, len(g))
+	for name, v := range g {
+		v.graphMu.RLock()
+		data, err := json.Marshal(v.refreshed().output())
+		v.graphMu.RUnlock()
+		if err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			w.Write([]byte("500 - failed to convert vizceral data into JSON"))
+			return
+		}
+		graphs[name] = data
+	}
+	err := json.NewEncoder(w).Encode(graphs)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert vizceral data into JSON"))
+		return
+	}
+}
@@ -0,0 +1,65 @@
+package collector
+
+import (
+	"context"
+
+	"cargo/cargopb"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// RegisterGRPC adds the Collector service to a gRPC server
+func (v *Vizceral) RegisterGRPC(s *grpc.Server) {
+	cargopb.RegisterCollectorServer(s, &grpcCollector{v: v})
+}
+
+// grpcCollector implements cargopb.CollectorServer on top of
+// the same increment logic as the HTTP handlers
+type grpcCollector struct {
+	cargopb.UnimplementedCollectorServer
+	v *Vizceral
+}
+
+func (g *grpcCollector) LogConnection(ctx context.Context, req *cargopb.LogConnectionRequest) (*cargopb.LogConnectionResponse, error) {
+	if g.v.isDraining() {
+		return nil, status.Error(codes.Unavailable, "draining")
+	}
+	count := int(req.GetCount())
+	if count < 0 {
+		return nil, status.Error(codes.InvalidArgument, "count must not be negative")
+	}
+	if count == 0 {
+		count = 1
+	}
+	var m Metrics
+	switch req.GetOutcome() {
+	case cargopb.Outcome_COMPLETE:
+		m.Normal = count * observationWeight
+	case cargopb.Outcome_FAILED:
+		m.Danger = count * observationWeight
+	default:
+		return nil, status.Errorf(codes.InvalidArgument, "unknown outcome %v", req.GetOutcome())
+	}
+	inc := increment{metrics: m}
+	if req.LatencyMs != nil {
+		if req.GetLatencyMs() < 0 {
+			return nil, status.Error(codes.InvalidArgument, "latency_ms must not be negative")
+		}
+		inc.latency = []float64{req.GetLatencyMs()}
+	}
+	connection := g.v.key(req.GetSource(), req.GetTarget())
+	if len(connection) > g.v.config.maxKeyLength() {
+		return nil, status.Error(codes.InvalidArgument, "connection key is too long")
+	}
+	switch g.v.logConnection(connection, inc) {
+	case logRejected:
+		return nil, status.Errorf(codes.NotFound, "did not find connection: %s", connection)
+	case logForbidden:
+		return nil, status.Errorf(codes.PermissionDenied, "connection is not in the config: %s", connection)
+	case logTimedOut:
+		return nil, status.Error(codes.Unavailable, "ingestion queue is full")
+	}
+	return &cargopb.LogConnectionResponse{}, nil
+}
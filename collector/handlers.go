@@ -0,0 +1,676 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// RegisterHandlers adds the log, read and control endpoints to mux
+func (v *Vizceral) RegisterHandlers(mux *http.ServeMux) {
+	v.RegisterLogHandlers(mux)
+	v.RegisterReadHandlers(mux)
+	v.RegisterControlHandlers(mux)
+}
+
+// maxLogBody is the largest body the log endpoints read
+const maxLogBody = 4 << 20
+
+// RegisterLogHandlers adds the log endpoints to mux
+func (v *Vizceral) RegisterLogHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/log/complete/", v.limited(v.signed(v.logCompletedConnection)))
+	mux.HandleFunc("/log/failed/", v.limited(v.signed(v.logFailedConnection)))
+	mux.HandleFunc("/log/bucket/", v.limited(v.signed(v.logBucketConnection)))
+	mux.HandleFunc("/log/bulk", v.limited(v.signed(v.logBulk)))
+	mux.HandleFunc("/log/envoy", v.limited(v.signed(v.logEnvoy)))
+	mux.HandleFunc("/log/", v.limited(v.signed(v.logCounts)))
+}
+
+// RegisterReadHandlers adds the endpoints reading the graph to mux
+func (v *Vizceral) RegisterReadHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/get", v.get)
+	mux.HandleFunc("/get/delta", v.getDelta)
+	mux.HandleFunc("/connection", v.getConnection)
+	mux.HandleFunc("/stats", v.stats)
+	mux.HandleFunc("/metrics", v.prometheusMetrics)
+	mux.HandleFunc("/healthz", v.healthz)
+	mux.HandleFunc("/readyz", v.readyz)
+	mux.HandleFunc("/history", v.getHistory)
+	mux.HandleFunc("/export/csv", v.exportCSV)
+	mux.HandleFunc("/export/config", v.exportConfig)
+}
+
+// RegisterControlHandlers adds the endpoints changing the state of
+// the collector to mux, maintenance mode and draining. A listener
+// serving the log endpoints on their own serves them as well, so they
+// are not left on the read side
+func (v *Vizceral) RegisterControlHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/maintenance", v.maintenanceMode)
+	mux.HandleFunc("/drain", v.drain(true))
+	mux.HandleFunc("/undrain", v.drain(false))
+}
+
+// RegisterHealthHandlers adds only the health endpoints to mux, for a
+// listener serving the log endpoints on their own
+func (v *Vizceral) RegisterHealthHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/healthz", v.healthz)
+	mux.HandleFunc("/readyz", v.readyz)
+}
+
+// RegisterAdminHandlers adds the endpoints that reveal
+// internal details to mux, which should not be publicly reachable
+func (v *Vizceral) RegisterAdminHandlers(mux *http.ServeMux) {
+	mux.HandleFunc("/config", v.showConfig)
+	mux.HandleFunc("/debug/live", v.debugLive)
+	mux.HandleFunc("/anonymize/mapping", v.anonymizeMapping)
+	mux.HandleFunc("/connection/metadata", v.connectionMetadata)
+}
+
+// limited wraps a log handler to count the requests in flight and
+// refuse them with 503 beyond MaxInFlight
+func (v *Vizceral) limited(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt64(&v.inFlight, 1)
+		defer atomic.AddInt64(&v.inFlight, -1)
+		if max := v.config.MaxInFlight; max > 0 && n > int64(max) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("503 - too many log requests in flight"))
+			return
+		}
+		handler(w, r)
+	}
+}
+
+// logFailedConnection logs a failed request, ?type= tells what kind of
+// failure it was, such as timeout, see Config.FailureTypes
+func (v *Vizceral) logFailedConnection(w http.ResponseWriter, r *http.Request) {
+	connection := r.URL.Path[12:]
+	failure := r.URL.Query().Get("type")
+	if failure != "" && !v.config.hasFailureType(failure) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unknown failure type"))
+		return
+	}
+	v.logRequest(w, r, connection, Metrics{Danger: observationWeight}, failure)
+}
+
+func (v *Vizceral) logCompletedConnection(w http.ResponseWriter, r *http.Request) {
+	connection := r.URL.Path[14:]
+	connection = strings.Trim(connection, "\n")
+	v.logRequest(w, r, connection, Metrics{Normal: observationWeight}, "")
+}
+
+// logBucketConnection logs a request into one of the configured
+// buckets, /log/bucket/web:db?bucket=critical
+func (v *Vizceral) logBucketConnection(w http.ResponseWriter, r *http.Request) {
+	connection := r.URL.Path[12:]
+	bucket := r.URL.Query().Get("bucket")
+	if !v.config.hasBucket(bucket) {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - unknown bucket"))
+		return
+	}
+	var m Metrics
+	m.addBucket(bucket, observationWeight)
+	v.logRequest(w, r, connection, m, "")
+}
+
+// logCounts logs the requests of a window for one connection at once,
+// POST /log/web:db with a body of {"normal": 95, "warning": 0, "danger": 5}
+func (v *Vizceral) logCounts(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	connection := r.URL.Path[5:]
+	var counts struct {
+		Normal  int `json:"normal"`
+		Warning int `json:"warning"`
+		Danger  int `json:"danger"`
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogBody)
+	if err := json.NewDecoder(r.Body).Decode(&counts); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - body must be a JSON object of counts"))
+		return
+	}
+	if counts.Normal < 0 || counts.Warning < 0 || counts.Danger < 0 {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - counts must not be negative"))
+		return
+	}
+	v.logRequest(w, r, connection, Metrics{
+		Normal:  counts.Normal * observationWeight,
+		Warning: counts.Warning * observationWeight,
+		Danger:  counts.Danger * observationWeight,
+	}, "")
+}
+
+// logRequest parses the optional parameters shared by the log
+// endpoints and records m against the connection. ?dim= additionally
+// records it under a label such as "GET /users", and ?cluster= under
+// the cluster that logged it. ?bytesIn= and ?bytesOut= count the bytes
+// the request transferred, and ?origin= is the address of the external
+// client of an entry connection, see EntryConfig.Origins
+func (v *Vizceral) logRequest(w http.ResponseWriter, r *http.Request, connection string, m Metrics, failure string) {
+	if v.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 - draining"))
+		return
+	}
+	if len(connection) > v.config.maxKeyLength() {
+		w.WriteHeader(http.StatusRequestURITooLong)
+		w.Write([]byte("414 - connection key is too long"))
+		return
+	}
+	latency, err := parseLatency(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - ms must be a non negative number"))
+		return
+	}
+	dimension := r.URL.Query().Get("dim")
+	if len(dimension) > v.config.maxKeyLength() {
+		w.WriteHeader(http.StatusRequestURITooLong)
+		w.Write([]byte("414 - dimension is too long"))
+		return
+	}
+	cluster := r.URL.Query().Get("cluster")
+	if len(cluster) > v.config.maxKeyLength() {
+		w.WriteHeader(http.StatusRequestURITooLong)
+		w.Write([]byte("414 - cluster is too long"))
+		return
+	}
+	bytes, err := parseBytes(r)
+	if err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - bytesIn and bytesOut must be non negative integers"))
+		return
+	}
+	connection = v.originConnection(connection, r.URL.Query().Get("origin"))
+	inc := increment{metrics: m, latency: latency, client: v.proxies.clientIP(r), dimension: dimension, cluster: cluster, failure: failure, bytes: bytes}
+	if v.config.AsyncLog && v.normalQueue != nil {
+		if v.logAsync(connection, inc) == logTimedOut {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			w.Write([]byte("503 - ingestion queue is full"))
+			return
+		}
+		w.WriteHeader(http.StatusAccepted)
+		return
+	}
+	switch v.logConnection(connection, inc) {
+	case logIgnored:
+		w.WriteHeader(http.StatusAccepted)
+	case logRejected:
+		w.WriteHeader(http.StatusNotAcceptable)
+	case logForbidden:
+		w.WriteHeader(http.StatusForbidden)
+		w.Write([]byte("403 - connection is not in the config"))
+	case logTimedOut:
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 - ingestion queue is full"))
+	}
+}
+
+// logBulk logs the requests of many connections in one call. The body
+// maps connection keys to request counts per bucket,
+// {"web:db": {"normal": 95, "danger": 5}}
+func (v *Vizceral) logBulk(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	if v.isDraining() {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		w.Write([]byte("503 - draining"))
+		return
+	}
+	var counts map[string]Metrics
+	r.Body = http.MaxBytesReader(w, r.Body, maxLogBody)
+	if err := json.NewDecoder(r.Body).Decode(&counts); err != nil {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - body must be a JSON object of connection metrics"))
+		return
+	}
+	batch := make(map[string]Metrics, len(counts))
+	for connection, m := range counts {
+		if len(connection) > v.config.maxKeyLength() {
+			w.WriteHeader(http.StatusRequestURITooLong)
+			w.Write([]byte("414 - connection key is too long"))
+			return
+		}
+		if m.Normal < 0 || m.Warning < 0 || m.Danger < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - counts must not be negative"))
+			return
+		}
+		batch[connection] = Metrics{
+			Normal:  m.Normal * observationWeight,
+			Warning: m.Warning * observationWeight,
+			Danger:  m.Danger * observationWeight,
+		}
+	}
+	rejected := v.logBatch(batch, v.proxies.clientIP(r))
+	if rejected == nil {
+		rejected = []string{}
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string][]string{"rejected": rejected})
+}
+
+// parseLatency reads the optional ms query parameter holding
+// the latency of the logged request in milliseconds
+func parseLatency(r *http.Request) ([]float64, error) {
+	ms := r.URL.Query().Get("ms")
+	if ms == "" {
+		return nil, nil
+	}
+	latency, err := strconv.ParseFloat(ms, 64)
+	if err != nil || latency < 0 {
+		return nil, fmt.Errorf("invalid latency %q", ms)
+	}
+	return []float64{latency}, nil
+}
+
+// get serves the graph. HEAD returns only the headers, including the
+// length of the body GET would return, and OPTIONS the allowed methods
+// ?pretty=1 indents the JSON, overriding the PrettyJSON default, and
+// ?cluster= only counts the metrics logged by that cluster
+func (v *Vizceral) get(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodOptions:
+		w.Header().Set("Allow", getMethods)
+		w.Header().Set("Access-Control-Allow-Methods", getMethods)
+		w.WriteHeader(http.StatusNoContent)
+		return
+	default:
+		w.Header().Set("Allow", getMethods)
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	v.graphMu.RLock()
+	defer v.graphMu.RUnlock()
+	view := v.refreshed()
+	// The graph only changes with a new sequence number, unless it is
+	// stale or in maintenance which is then always served in full
+	if view.Sequence > 0 && !view.Stale && !view.Maintenance {
+		etag := fmt.Sprintf(`W/"%d"`, view.Sequence)
+		w.Header().Set("ETag", etag)
+		if r.Header.Get("If-None-Match") == etag {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+	}
+
+	if v.config.PreviewFirstWindow && atomic.LoadInt32(&v.rotated) == 0 {
+		view = view.withShadowMetrics()
+	}
+	if cluster := r.URL.Query().Get("cluster"); cluster != "" {
+		if !v.config.Clusters {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - clusters are not enabled"))
+			return
+		}
+		view = view.forCluster(cluster)
+	}
+	if top := r.URL.Query().Get("top"); top != "" {
+		n, err := strconv.Atoi(top)
+		if err != nil || n < 0 {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - top must be a non negative integer"))
+			return
+		}
+		view = view.top(n)
+	}
+	view = view.output()
+	if renderers := v.config.renderers(clientVersion(r)); renderers != nil {
+		view = view.withRenderers(renderers)
+	}
+
+	pretty := v.config.PrettyJSON
+	if p := r.URL.Query().Get("pretty"); p != "" {
+		var err error
+		pretty, err = strconv.ParseBool(p)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - pretty must be true or false"))
+			return
+		}
+	}
+
+	max := v.config.MaxResponseBytes
+	if max > 0 && view.estimatedSize() > max {
+		tooLarge(w)
+		return
+	}
+
+	var buf bytes.Buffer
+	enc := json.NewEncoder(&buf)
+	if pretty {
+		enc.SetIndent("", "  ")
+	}
+	err := enc.Encode(view)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert vizceral data into JSON"))
+		return
+	}
+	if max > 0 && buf.Len() > max {
+		tooLarge(w)
+		return
+	}
+	w.Header().Set("Content-Length", strconv.Itoa(buf.Len()))
+	if r.Method == http.MethodHead {
+		return
+	}
+	w.Write(buf.Bytes())
+}
+
+// getDelta returns the connections that changed after ?since=<updated>,
+// with the updated value to pass as since on the next poll. The
+// client merges them into the graph it got from /get. When
+// Config.Sequence is set ?sequence=<sequence> can be passed instead,
+// which unlike updated never repeats between two snapshots
+func (v *Vizceral) getDelta(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - since must be a unix timestamp"))
+			return
+		}
+	}
+	var sequence uint64
+	bySequence := false
+	if s := r.URL.Query().Get("sequence"); s != "" && v.config.Sequence {
+		var err error
+		sequence, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - sequence must be a non negative integer"))
+			return
+		}
+		bySequence = true
+	}
+	v.graphMu.RLock()
+	defer v.graphMu.RUnlock()
+	view := v.output()
+	connections := []*VizceralConnection{}
+	for _, con := range view.ConnectionMap.connections {
+		if bySequence && con.changedSequence > sequence || !bySequence && con.changed > since {
+			connections = append(connections, con)
+		}
+	}
+	resp := struct {
+		Updated     int64                 `json:"updated"`
+		Sequence    uint64                `json:"sequence,omitempty"`
+		Connections []*VizceralConnection `json:"connections"`
+	}{
+		Updated:     atomic.LoadInt64(&v.lastSnapshot) / int64(time.Second),
+		Sequence:    v.Sequence,
+		Connections: connections,
+	}
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert vizceral data into JSON"))
+		return
+	}
+}
+
+// connectionState is the detail of one connection served by /connection
+// Changed is the unix time of the last snapshot that changed its
+// metrics or class and LastSeen that of the last window with traffic
+type connectionState struct {
+	Source        string   `json:"source"`
+	Target        string   `json:"target"`
+	Metrics       Metrics  `json:"metrics"`
+	ShadowMetrics Metrics  `json:"shadowMetrics"`
+	Class         string   `json:"class,omitempty"`
+	Latency       *Latency `json:"latency,omitempty"`
+	Changed       int64    `json:"changed"`
+	LastSeen      int64    `json:"lastSeen"`
+}
+
+// getConnection serves the committed and in progress metrics of the
+// connection given by ?source= and ?target=, or 404 when there is none
+func (v *Vizceral) getConnection(w http.ResponseWriter, r *http.Request) {
+	source, target := r.URL.Query().Get("source"), r.URL.Query().Get("target")
+	if source == "" || target == "" {
+		w.WriteHeader(http.StatusBadRequest)
+		w.Write([]byte("400 - source and target are required"))
+		return
+	}
+	v.graphMu.RLock()
+	con, ok := v.connection(v.key(source, target))
+	if !ok {
+		v.graphMu.RUnlock()
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - connection not found"))
+		return
+	}
+	state := connectionState{
+		Source:   source,
+		Target:   target,
+		Metrics:  con.Metrics,
+		Class:    con.Class,
+		Latency:  con.Latency,
+		Changed:  con.changed,
+		LastSeen: con.lastSeen,
+	}
+	con.mu.Lock()
+	state.ShadowMetrics.Add(con.shadowMetrics)
+	con.mu.Unlock()
+	v.graphMu.RUnlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(state); err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert connection into JSON"))
+	}
+}
+
+// Rough serialized sizes of a node and a connection, used to refuse
+// graphs beyond MaxResponseBytes before encoding them
+const (
+	estimatedNodeBytes       = 120
+	estimatedConnectionBytes = 160
+)
+
+// estimatedSize returns a lower estimate of the size of the graph in JSON
+func (v *Vizceral) estimatedSize() int {
+	return len(v.NodeMap.nodes)*estimatedNodeBytes + len(v.ConnectionMap.connections)*estimatedConnectionBytes
+}
+
+func tooLarge(w http.ResponseWriter) {
+	w.WriteHeader(http.StatusRequestEntityTooLarge)
+	w.Write([]byte("413 - graph is too large, use ?top=N or ?cluster= to reduce it"))
+}
+
+// getMethods are the methods served by /get
+const getMethods = "GET, HEAD, OPTIONS"
+
+// maintenanceMode toggles maintenance with POST /maintenance?on=true|false
+// and reports the current state
+func (v *Vizceral) maintenanceMode(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		on, err := strconv.ParseBool(r.URL.Query().Get("on"))
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - on must be true or false"))
+			return
+		}
+		v.setMaintenance(on)
+		log.Printf("maintenance mode set to %t", on)
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]bool{"maintenance": v.inMaintenance()})
+}
+
+// drain returns a handler for POST /drain and /undrain, which stop
+// and resume accepting log events while reads stay available
+func (v *Vizceral) drain(on bool) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		v.setDraining(on)
+		log.Printf("draining set to %t", on)
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]bool{"draining": on})
+	}
+}
+
+// healthz reports that cargo is up along with its drain state
+func (v *Vizceral) healthz(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"status":   "ok",
+		"draining": v.isDraining(),
+	})
+}
+
+// Readiness criteria of /readyz, see Config.Readiness
+const (
+	readyListening = "listening"
+	readyConfig    = "config"
+	readySnapshot  = "snapshot"
+)
+
+// readyz responds 200 once cargo meets the Readiness criterion and
+// 503 before that or while draining
+func (v *Vizceral) readyz(w http.ResponseWriter, r *http.Request) {
+	reason := ""
+	switch {
+	case v.isDraining():
+		reason = "draining"
+	case v.config.Readiness == readySnapshot && atomic.LoadInt32(&v.rotated) == 0:
+		reason = "waiting for the first snapshot"
+	}
+	w.Header().Set("Content-Type", "application/json")
+	if reason != "" {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		json.NewEncoder(w).Encode(map[string]string{"status": "not ready", "reason": reason})
+		return
+	}
+	json.NewEncoder(w).Encode(map[string]string{"status": "ready"})
+}
+
+// stats reports internal counters of the collector
+func (v *Vizceral) stats(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	resp := map[string]interface{}{
+		"dropped": map[string]uint64{
+			"normal": atomic.LoadUint64(&v.droppedNormal),
+			"danger": atomic.LoadUint64(&v.droppedDanger),
+		},
+		"unknown": map[string]uint64{
+			"rejected":  atomic.LoadUint64(&v.unknownRejected),
+			"created":   atomic.LoadUint64(&v.unknownCreated),
+			"ignored":   atomic.LoadUint64(&v.unknownIgnored),
+			"evicted":   atomic.LoadUint64(&v.evicted),
+			"forbidden": atomic.LoadUint64(&v.unknownForbidden),
+		},
+		"graph": map[string]int64{
+			"connections":    atomic.LoadInt64(&v.connectionCount),
+			"nodes":          atomic.LoadInt64(&v.nodeCount),
+			"maxConnections": int64(v.config.MaxConnections),
+		},
+		"locks": map[string]interface{}{
+			"shards":    v.shardStats(),
+			"dedicated": atomic.LoadInt64(&v.dedicatedLocks),
+		},
+		"udp": map[string]uint64{
+			"malformed": atomic.LoadUint64(&v.udpMalformed),
+		},
+		"discovery": map[string]uint64{
+			"created": atomic.LoadUint64(&v.discovered),
+		},
+		"queues":   v.queues(),
+		"outputs":  v.outbound.stats(),
+		"inFlight": atomic.LoadInt64(&v.inFlight),
+
+		"suppressedNotices": atomic.LoadUint64(&v.suppressedNotices),
+	}
+	if v.history != nil {
+		retained, oldest := v.history.stats()
+		resp["history"] = map[string]int64{
+			"retained": int64(retained),
+			"oldest":   oldest,
+		}
+	}
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert stats into JSON"))
+		return
+	}
+}
+
+// showConfig returns the effective configuration as JSON
+// It is only served on the admin listener as it reveals the topology
+func (v *Vizceral) showConfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(v.config)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert config into JSON"))
+		return
+	}
+}
+
+// liveConnection is a connection with its in progress window
+type liveConnection struct {
+	Source        string  `json:"source"`
+	Target        string  `json:"target"`
+	Metrics       Metrics `json:"metrics"`
+	ShadowMetrics Metrics `json:"shadowMetrics"`
+}
+
+// debugLive returns the committed and not yet rotated metrics
+// of every connection, read under the connection's mutex
+func (v *Vizceral) debugLive(w http.ResponseWriter, r *http.Request) {
+	v.graphMu.RLock()
+	defer v.graphMu.RUnlock()
+	live := make([]liveConnection, 0, len(v.ConnectionMap.connections))
+	for _, con := range v.ConnectionMap.connections {
+		con.mu.Lock()
+		live = append(live, liveConnection{
+			Source:        con.Source,
+			Target:        con.Target,
+			Metrics:       con.Metrics,
+			ShadowMetrics: con.shadowMetrics,
+		})
+		con.mu.Unlock()
+	}
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(live)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert live metrics into JSON"))
+		return
+	}
+}
@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"sort"
+	"strconv"
+	"sync/atomic"
+)
+
+// defaultHistogramBuckets are the default buckets of the Prometheus
+// client libraries, in seconds
+var defaultHistogramBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// HistogramConfig serves the reported latencies of connections
+// as the Prometheus histogram cargo_connection_latency_seconds on
+// /metrics, so percentiles can be computed by Prometheus over any
+// range. Buckets are the upper bounds in seconds, defaulting to those
+// of the Prometheus client libraries. MaxConnections bounds the
+// connections given a histogram to keep the series bounded, the first
+// ones to report a latency get one, defaulting to 500
+type HistogramConfig struct {
+	Enabled        bool      `yaml:"enabled" json:"enabled"`
+	Buckets        []float64 `yaml:"buckets" json:"buckets"`
+	MaxConnections int       `yaml:"maxConnections" json:"maxConnections"`
+}
+
+func (c HistogramConfig) buckets() []float64 {
+	if len(c.Buckets) == 0 {
+		return defaultHistogramBuckets
+	}
+	buckets := append([]float64(nil), c.Buckets...)
+	sort.Float64s(buckets)
+	return buckets
+}
+
+func (c HistogramConfig) maxConnections() int64 {
+	if c.MaxConnections <= 0 {
+		return 500
+	}
+	return int64(c.MaxConnections)
+}
+
+// latencyHistogram holds the cumulative latency counts of a connection
+// since it was created, counts[i] of the requests within buckets[i]
+type latencyHistogram struct {
+	counts []uint64
+	count  uint64
+	sum    float64
+}
+
+// observe adds a latency in milliseconds to the histogram
+func (h *latencyHistogram) observe(ms float64, buckets []float64) {
+	seconds := ms / 1000
+	for i, bound := range buckets {
+		if seconds <= bound {
+			h.counts[i]++
+		}
+	}
+	h.count++
+	h.sum += seconds
+}
+
+// observeLatency adds the latencies of an increment to the histogram
+// of its connection, creating it while there are fewer than
+// LatencyHistogram.MaxConnections. The caller must hold the
+// connection's mutex
+func (v *Vizceral) observeLatency(con *VizceralConnection, latency []float64) {
+	if !v.config.LatencyHistogram.Enabled || len(latency) == 0 {
+		return
+	}
+	if con.histogram == nil {
+		if atomic.AddInt64(&v.histograms, 1) > v.config.LatencyHistogram.maxConnections() {
+			atomic.AddInt64(&v.histograms, -1)
+			return
+		}
+		con.histogram = &latencyHistogram{counts: make([]uint64, len(v.histogramBuckets))}
+	}
+	for _, ms := range latency {
+		con.histogram.observe(ms, v.histogramBuckets)
+	}
+}
+
+// writeLatencyHistograms writes the latency histogram of every
+// connection that has one
+func (v *Vizceral) writeLatencyHistograms(p *promWriter) {
+	if !v.config.LatencyHistogram.Enabled {
+		return
+	}
+	type series struct {
+		source, target string
+		histogram      latencyHistogram
+	}
+	var all []series
+	v.graphMu.RLock()
+	for _, con := range v.allConnections() {
+		con.mu.Lock()
+		if h := con.histogram; h != nil {
+			all = append(all, series{con.Source, con.Target, latencyHistogram{
+				counts: append([]uint64(nil), h.counts...), count: h.count, sum: h.sum}})
+		}
+		con.mu.Unlock()
+	}
+	v.graphMu.RUnlock()
+	sort.Slice(all, func(i, j int) bool {
+		if all[i].source != all[j].source {
+			return all[i].source < all[j].source
+		}
+		return all[i].target < all[j].target
+	})
+
+	const name = "cargo_connection_latency_seconds"
+	p.family(name, "histogram", "Latency reported for the connection.")
+	for _, s := range all {
+		for i, bound := range v.histogramBuckets {
+			p.sample(name+"_bucket", float64(s.histogram.counts[i]),
+				"source", s.source, "target", s.target, "le", strconv.FormatFloat(bound, 'g', -1, 64))
+		}
+		p.sample(name+"_bucket", float64(s.histogram.count), "source", s.source, "target", s.target, "le", "+Inf")
+		p.sample(name+"_sum", s.histogram.sum, "source", s.source, "target", s.target)
+		p.sample(name+"_count", float64(s.histogram.count), "source", s.source, "target", s.target)
+	}
+}
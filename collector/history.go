@@ -0,0 +1,153 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// historyEntry is a past snapshot of the graph, Sequence numbers the
+// snapshots so that two taken within the same second can be told apart
+type historyEntry struct {
+	Updated     int64               `json:"updated"`
+	Sequence    uint64              `json:"sequence"`
+	MaxVolume   int                 `json:"maxVolume"`
+	Connections []historyConnection `json:"connections"`
+}
+
+// historyConnection is a connection as it was in a past snapshot
+type historyConnection struct {
+	Source  string  `json:"source"`
+	Target  string  `json:"target"`
+	Metrics Metrics `json:"metrics"`
+	Class   string  `json:"class,omitempty"`
+}
+
+// history keeps the most recent snapshots, oldest first
+// Entries beyond size, or older than retention, are evicted
+type history struct {
+	mu        sync.Mutex
+	entries   []historyEntry
+	size      int
+	retention time.Duration
+}
+
+func (h *history) add(entry historyEntry) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.entries = append(h.entries, entry)
+	drop := 0
+	if h.size > 0 && len(h.entries) > h.size {
+		drop = len(h.entries) - h.size
+	}
+	if h.retention > 0 {
+		oldest := entry.Updated - int64(h.retention/time.Second)
+		for drop < len(h.entries) && h.entries[drop].Updated < oldest {
+			drop++
+		}
+	}
+	if drop > 0 {
+		h.entries = append([]historyEntry(nil), h.entries[drop:]...)
+	}
+}
+
+// stats returns the number of retained entries and the time of the oldest
+func (h *history) stats() (int, int64) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	if len(h.entries) == 0 {
+		return 0, 0
+	}
+	return len(h.entries), h.entries[0].Updated
+}
+
+// since returns the entries newer than the given unix time, or with
+// bySequence those after the given sequence number
+func (h *history) since(updated int64, sequence uint64, bySequence bool) []historyEntry {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	entries := []historyEntry{}
+	for _, entry := range h.entries {
+		if bySequence && entry.Sequence > sequence || !bySequence && entry.Updated > updated {
+			entries = append(entries, entry)
+		}
+	}
+	return entries
+}
+
+// recordHistory adds the current snapshot to the history
+func (v *Vizceral) recordHistory(updated int64) {
+	if v.history == nil {
+		return
+	}
+	entry := historyEntry{Updated: updated, Sequence: v.sequence, MaxVolume: v.MaxVolume}
+	for _, con := range v.ConnectionMap.connections {
+		entry.Connections = append(entry.Connections, historyConnection{
+			Source:  con.Source,
+			Target:  con.Target,
+			Metrics: con.Metrics,
+			Class:   con.Class,
+		})
+	}
+	v.history.add(entry)
+}
+
+// getHistory returns the retained snapshots, limited to those newer
+// than ?since=<unix> when given, along with the time of the newest one
+// to pass as since on the next poll. When Config.Sequence is set
+// ?sequence=<sequence> can be given instead along with the sequence of
+// the newest one, which unlike the time never repeats between two
+// snapshots
+func (v *Vizceral) getHistory(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Access-Control-Allow-Origin", "*")
+	w.Header().Set("Content-Type", "application/json")
+	if v.history == nil {
+		w.WriteHeader(http.StatusNotFound)
+		w.Write([]byte("404 - history is not enabled"))
+		return
+	}
+	var since int64
+	if s := r.URL.Query().Get("since"); s != "" {
+		var err error
+		since, err = strconv.ParseInt(s, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - since must be a unix timestamp"))
+			return
+		}
+	}
+	var sequence uint64
+	bySequence := false
+	if s := r.URL.Query().Get("sequence"); s != "" && v.config.Sequence {
+		var err error
+		sequence, err = strconv.ParseUint(s, 10, 64)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - sequence must be a non negative integer"))
+			return
+		}
+		bySequence = true
+	}
+	snapshots := v.history.since(since, sequence, bySequence)
+	// the cursors stay where they were when there is nothing newer
+	if n := len(snapshots); n > 0 {
+		since, sequence = snapshots[n-1].Updated, snapshots[n-1].Sequence
+	}
+	resp := struct {
+		Now       int64          `json:"now"`
+		Sequence  uint64         `json:"sequence"`
+		Snapshots []historyEntry `json:"snapshots"`
+	}{
+		Now:       since,
+		Sequence:  sequence,
+		Snapshots: snapshots,
+	}
+	err := json.NewEncoder(w).Encode(resp)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert history into JSON"))
+		return
+	}
+}
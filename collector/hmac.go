@@ -0,0 +1,67 @@
+package collector
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/ioutil"
+	"net/http"
+)
+
+// HMACConfig requires the log endpoints to be signed with Secret
+// The signature is the hex encoded HMAC-SHA256 of the method, the
+// request URI and the body, separated by newlines, sent in the Header,
+// which defaults to X-Cargo-Signature. An empty Secret disables it
+type HMACConfig struct {
+	Secret string `yaml:"secret" json:"-"`
+	Header string `yaml:"header" json:"header"`
+}
+
+func (c HMACConfig) header() string {
+	if c.Header == "" {
+		return "X-Cargo-Signature"
+	}
+	return c.Header
+}
+
+// sign returns the signature of a request with the given body
+func (c HMACConfig) sign(r *http.Request, body []byte) []byte {
+	mac := hmac.New(sha256.New, []byte(c.Secret))
+	mac.Write([]byte(r.Method + "\n" + r.URL.RequestURI() + "\n"))
+	mac.Write(body)
+	return mac.Sum(nil)
+}
+
+// signed wraps a log handler so that requests without a valid
+// signature are refused with 401 when HMAC is configured. Bodies over
+// maxLogBody are refused with 413 before they are checked
+func (v *Vizceral) signed(handler http.HandlerFunc) http.HandlerFunc {
+	c := v.config.HMAC
+	if c.Secret == "" {
+		return handler
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		body, err := ioutil.ReadAll(http.MaxBytesReader(w, r.Body, maxLogBody))
+		var tooLarge *http.MaxBytesError
+		if errors.As(err, &tooLarge) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			w.Write([]byte("413 - body is too large"))
+			return
+		}
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			w.Write([]byte("400 - failed to read body"))
+			return
+		}
+		signature, err := hex.DecodeString(r.Header.Get(c.header()))
+		if err != nil || !hmac.Equal(signature, c.sign(r, body)) {
+			w.WriteHeader(http.StatusUnauthorized)
+			w.Write([]byte("401 - missing or invalid signature"))
+			return
+		}
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		handler(w, r)
+	}
+}
@@ -0,0 +1,28 @@
+package collector
+
+// PostProcess is a hook run after every snapshot, before the new
+// metrics are served. It runs with the graph locked so it may freely
+// modify nodes and connections, but must not call back into handlers
+type PostProcess func(v *Vizceral)
+
+// AddPostProcess registers a hook to run after every snapshot.
+// No hooks are registered by default
+func (v *Vizceral) AddPostProcess(p PostProcess) {
+	v.graphMu.Lock()
+	defer v.graphMu.Unlock()
+	v.postProcess = append(v.postProcess, p)
+}
+
+// Range calls fn for every node
+func (nodes *VizceralNodes) Range(fn func(name string, node *VizceralNode)) {
+	for name, node := range nodes.nodes {
+		fn(name, node)
+	}
+}
+
+// Range calls fn for every connection with its source:target key
+func (connections *VizceralConnections) Range(fn func(key string, con *VizceralConnection)) {
+	for key, con := range connections.connections {
+		fn(key, con)
+	}
+}
@@ -0,0 +1,365 @@
+package collector
+
+import (
+	"log"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// observationWeight is how much a single logged request adds to a bucket
+const observationWeight = 25
+
+// sampleScale is the precision of a connection's sample rate when
+// scaling its increments
+const sampleScale = 1000000
+
+// sampled extrapolates metrics logged against the connection to all
+// of its traffic by its sample rate
+func (con *VizceralConnection) sampled(m Metrics) Metrics {
+	if rate := con.sampleRate; rate > 0 && rate < 1 {
+		return m.scaled(sampleScale, int(rate*sampleScale))
+	}
+	return m
+}
+
+// increment is a pending update to a connection's shadowMetrics
+// latency holds any reported latency samples in milliseconds
+// client is the address of the reporter, used for logging
+// dimension is the optional label the metrics are also recorded under
+// cluster is the cluster that logged them, see Config.Clusters
+// failure is the kind of failure of a failed request, if reported
+// bytes is the data the request transferred, nil when not reported
+// key is the connection key of asynchronous increments, which are
+// queued before con is looked up
+type increment struct {
+	con       *VizceralConnection
+	key       string
+	metrics   Metrics
+	latency   []float64
+	client    string
+	dimension string
+	cluster   string
+	failure   string
+	bytes     *Bytes
+}
+
+// startIngestion creates the ingestion queues and their consumer
+// when buffering is configured, otherwise increments are applied inline
+func (v *Vizceral) startIngestion() {
+	buf := v.config.Buffer
+	if v.config.StrictConnections && v.config.UnknownConnections != "" {
+		log.Printf("warning: strictConnections is set, ignoring unknownConnections: %s", v.config.UnknownConnections)
+	}
+	if buf.Size <= 0 {
+		if v.config.AsyncLog {
+			log.Printf("warning: asyncLog requires buffer.size, logging synchronously")
+		}
+		return
+	}
+	dangerSize := buf.DangerSize
+	if dangerSize <= 0 {
+		dangerSize = buf.Size
+	}
+	switch buf.Policy {
+	case "", bufferDrop, bufferBlock:
+	default:
+		log.Fatalf("invalid buffer.policy %s, expected %s or %s", buf.Policy, bufferDrop, bufferBlock)
+	}
+	if v.config.AsyncLog {
+		log.Printf("asynchronous logging enabled, log requests are answered 202")
+	}
+	v.normalQueue = make(chan increment, buf.Size)
+	v.dangerQueue = make(chan increment, dangerSize)
+	log.Printf("buffered ingestion enabled (normal=%d, danger=%d)", buf.Size, dangerSize)
+	go v.ingestLoop()
+}
+
+// ingestLoop drains the queues, always preferring pending danger increments
+func (v *Vizceral) ingestLoop() {
+	for {
+		select {
+		case inc := <-v.dangerQueue:
+			v.apply(inc)
+			continue
+		default:
+		}
+		select {
+		case inc := <-v.dangerQueue:
+			v.apply(inc)
+		case inc := <-v.normalQueue:
+			v.apply(inc)
+		}
+	}
+}
+
+// Results of logging against a connection key
+const (
+	logRecorded = iota
+	logIgnored
+	logRejected
+	logForbidden
+	logTimedOut
+)
+
+// Ways of handling a connection key that does not exist
+const (
+	unknownReject = "reject"
+	unknownCreate = "create"
+	unknownIgnore = "ignore"
+)
+
+// logConnection records metrics against the connection with the given
+// key. Unknown keys are handled according to the UnknownConnections
+// config. It is shared by every ingestion transport
+func (v *Vizceral) logConnection(connection string, inc increment) int {
+	con, result := v.lookup(connection, inc.client)
+	if con == nil {
+		return result
+	}
+	inc.con = con
+	return v.record(inc)
+}
+
+// lookup returns the connection with the given key, handling unknown
+// keys according to the UnknownConnections config
+func (v *Vizceral) lookup(connection, client string) (*VizceralConnection, int) {
+	v.graphMu.RLock()
+	con, ok := v.connection(connection)
+	v.graphMu.RUnlock()
+	if ok {
+		return con, logRecorded
+	}
+	return v.unknownConnection(connection, client)
+}
+
+// logAsync queues metrics for the connection with the given key
+// without looking it up, unknown keys are then only counted
+func (v *Vizceral) logAsync(connection string, inc increment) int {
+	inc.key = connection
+	return v.record(inc)
+}
+
+// unknownConnection handles a logged key that matches no connection,
+// returning the connection when one was created
+func (v *Vizceral) unknownConnection(connection, client string) (*VizceralConnection, int) {
+	if v.config.StrictConnections {
+		atomic.AddUint64(&v.unknownForbidden, 1)
+		log.Printf("refused connection not in the config: %s", connection)
+		return nil, logForbidden
+	}
+	switch v.config.UnknownConnections {
+	case unknownIgnore:
+		atomic.AddUint64(&v.unknownIgnored, 1)
+		return nil, logIgnored
+	case unknownCreate:
+		if con := v.createConnection(connection, &v.unknownCreated); con != nil {
+			return con, logRecorded
+		}
+	}
+	atomic.AddUint64(&v.unknownRejected, 1)
+	if client != "" {
+		log.Printf("did not find connection: %s (from %s)", connection, client)
+	} else {
+		log.Printf("did not find connection: %s", connection)
+	}
+	return nil, logRejected
+}
+
+// logBatch applies the metrics of many connections at once. Lookups
+// share one read lock of the graph and a connection lock is only
+// released when the next connection is guarded by another shard, so a
+// batch takes every lock far fewer times than logging each connection.
+// It skips the ingestion queues and returns the keys that were rejected
+func (v *Vizceral) logBatch(batch map[string]Metrics, client string) []string {
+	var rejected, unknown []string
+	var held *shard
+	v.graphMu.RLock()
+	for connection, m := range batch {
+		con, ok := v.connection(connection)
+		if !ok {
+			unknown = append(unknown, connection)
+			continue
+		}
+		for ; con != nil; con = con.parent {
+			sampled := con.sampled(m)
+			held = lockSwap(held, con.mu)
+			con.shadowMetrics.Add(sampled)
+			if v.config.Clusters {
+				con.shadowClusters.addCluster("", sampled)
+			}
+		}
+	}
+	lockSwap(held, nil)
+	v.graphMu.RUnlock()
+
+	for _, connection := range unknown {
+		con, result := v.unknownConnection(connection, client)
+		if con == nil {
+			if result == logRejected || result == logForbidden {
+				rejected = append(rejected, connection)
+			}
+			continue
+		}
+		m := con.sampled(batch[connection])
+		con.mu.Lock()
+		con.shadowMetrics.Add(m)
+		if v.config.Clusters {
+			con.shadowClusters.addCluster("", m)
+		}
+		con.mu.Unlock()
+	}
+	return rejected
+}
+
+// createConnection adds a connection for a source:target key that
+// was logged or discovered but not configured, along with any missing
+// nodes, counting it in created
+func (v *Vizceral) createConnection(connection string, created *uint64) *VizceralConnection {
+	parts := strings.SplitN(connection, v.config.keySeparator(), 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil
+	}
+	v.graphMu.Lock()
+	defer v.graphMu.Unlock()
+	if con, ok := v.ConnectionMap.connections[connection]; ok {
+		return con
+	}
+	if max := v.config.MaxConnections; max > 0 && len(v.ConnectionMap.connections) >= max && !v.evict() {
+		log.Printf("not creating connection %s, maxConnections reached", connection)
+		return nil
+	}
+	for _, name := range parts {
+		if _, ok := v.NodeMap.nodes[name]; !ok {
+			node := v.addNode(name, "region")
+			node.Updated = v.Updated
+			node.UpdatedAt = v.UpdatedAt
+			if v.grouped {
+				v.setGroup(name, node)
+			}
+		}
+	}
+	atomic.AddUint64(created, 1)
+	log.Printf("creating connection %s", connection)
+	con := v.addConnection(parts[0], parts[1])
+	con.created = true
+	con.lastSeen = time.Now().Unix()
+	return con
+}
+
+// evict removes the least recently observed of the created
+// connections, along with the created nodes it leaves unconnected,
+// and reports whether one was removed. The caller must hold graphMu
+func (v *Vizceral) evict() bool {
+	var oldestKey string
+	var oldest *VizceralConnection
+	for key, con := range v.ConnectionMap.connections {
+		if con.created && (oldest == nil || con.lastSeen < oldest.lastSeen) {
+			oldestKey, oldest = key, con
+		}
+	}
+	if oldest == nil {
+		return false
+	}
+	delete(v.ConnectionMap.connections, oldestKey)
+	v.webhookFilter.forget(oldestKey)
+	atomic.AddUint64(&v.evicted, 1)
+	oldest.mu.Lock()
+	if oldest.histogram != nil {
+		atomic.AddInt64(&v.histograms, -1)
+	}
+	oldest.mu.Unlock()
+	log.Printf("evicted connection %s, last observed at %d", oldestKey, oldest.lastSeen)
+
+	for _, name := range []string{oldest.Source, oldest.Target} {
+		if _, tier := v.config.Ships[name]; tier || name == v.EntryNode || name == loopbackName || v.isOrigin(name) {
+			continue
+		}
+		connected := false
+		for _, con := range v.ConnectionMap.connections {
+			if con.Source == name || con.Target == name {
+				connected = true
+				break
+			}
+		}
+		if !connected {
+			delete(v.NodeMap.nodes, name)
+		}
+	}
+	return true
+}
+
+// record adds metrics to a connection, either directly or via the
+// queues. When a queue is full the increment is dropped and counted,
+// or with the block policy waited on for up to MaxWait
+func (v *Vizceral) record(inc increment) int {
+	if v.normalQueue == nil {
+		v.apply(inc)
+		return logRecorded
+	}
+	queue, dropped, timedOut := v.normalQueue, &v.droppedNormal, &v.timedOutNormal
+	if inc.metrics.Danger > 0 {
+		queue, dropped, timedOut = v.dangerQueue, &v.droppedDanger, &v.timedOutDanger
+	}
+	select {
+	case queue <- inc:
+		return logRecorded
+	default:
+	}
+	if v.config.Buffer.Policy != bufferBlock {
+		atomic.AddUint64(dropped, 1)
+		return logRecorded
+	}
+	if v.config.Buffer.MaxWait <= 0 {
+		queue <- inc
+		return logRecorded
+	}
+	timer := time.NewTimer(v.config.Buffer.MaxWait)
+	defer timer.Stop()
+	select {
+	case queue <- inc:
+		return logRecorded
+	case <-timer.C:
+		atomic.AddUint64(timedOut, 1)
+		return logTimedOut
+	}
+}
+
+func (v *Vizceral) apply(inc increment) {
+	if inc.con == nil {
+		if inc.con, _ = v.lookup(inc.key, inc.client); inc.con == nil {
+			return
+		}
+	}
+	if parent := inc.con.parent; parent != nil {
+		defer v.apply(increment{con: parent, metrics: inc.metrics, latency: inc.latency,
+			dimension: inc.dimension, cluster: inc.cluster, failure: inc.failure, bytes: inc.bytes})
+	}
+	inc.metrics = inc.con.sampled(inc.metrics)
+	inc.con.mu.Lock()
+	inc.con.shadowMetrics.Add(inc.metrics)
+	for _, ms := range inc.latency {
+		inc.con.shadowLatency.add(ms)
+		inc.con.shadowLatencySLO.add(ms, inc.con.latencySLO)
+	}
+	v.observeLatency(inc.con, inc.latency)
+	if inc.dimension != "" {
+		inc.con.shadowDimensions.add(inc.dimension, inc.metrics, v.config.maxDimensions())
+	}
+	if v.config.Clusters {
+		inc.con.shadowClusters.addCluster(inc.cluster, inc.metrics)
+	}
+	if inc.failure != "" {
+		if inc.con.shadowFailures == nil {
+			inc.con.shadowFailures = make(map[string]int)
+		}
+		inc.con.shadowFailures[inc.failure]++
+	}
+	if inc.bytes != nil {
+		if inc.con.shadowBytes == nil {
+			inc.con.shadowBytes = &Bytes{}
+		}
+		inc.con.shadowBytes.add(inc.bytes)
+	}
+	inc.con.mu.Unlock()
+}
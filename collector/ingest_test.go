@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func newBenchVizceral(shards, connections int) *Vizceral {
+	v := &Vizceral{counters: &counters{}, graphMu: &sync.RWMutex{}, ConnectionMap: &VizceralConnections{connections: make(map[string]*VizceralConnection)}}
+	v.createLocks(shards)
+	for i := 0; i < connections; i++ {
+		key := fmt.Sprintf("web:10.0.0.%d", i)
+		v.ConnectionMap.connections[key] = &VizceralConnection{mu: v.lockFor(key), ingested: &ingested{}}
+	}
+	return v
+}
+
+func benchmarkRecord(b *testing.B, shards int) {
+	v := newBenchVizceral(shards, 64)
+	var cons []*VizceralConnection
+	for _, con := range v.ConnectionMap.connections {
+		cons = append(cons, con)
+	}
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		i := 0
+		for pb.Next() {
+			v.record(increment{con: cons[i%len(cons)], metrics: Metrics{Normal: 25}})
+			i++
+		}
+	})
+}
+
+func BenchmarkRecordSingleLock(b *testing.B) { benchmarkRecord(b, 0) }
+func BenchmarkRecordSharded(b *testing.B)    { benchmarkRecord(b, 16) }
+
+func BenchmarkLogPerEvent(b *testing.B) {
+	v := newBenchVizceral(0, 64)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		for key := range v.ConnectionMap.connections {
+			v.logConnection(key, increment{metrics: Metrics{Normal: 25}})
+		}
+	}
+}
+
+func BenchmarkLogBatch(b *testing.B) {
+	v := newBenchVizceral(0, 64)
+	batch := make(map[string]Metrics)
+	for key := range v.ConnectionMap.connections {
+		batch[key] = Metrics{Normal: 25}
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		v.logBatch(batch, "")
+	}
+}
+
+func TestLogBulkSampled(t *testing.T) {
+	v, server := newTestServer(t, Config{
+		Ships: map[string]Ship{
+			"web": {
+				Clients:     []string{"10.0.0.1:80", "10.0.0.2:80"},
+				Connections: map[string]ConnectionConfig{"10.0.0.1": {SampleRate: 0.1}},
+			},
+		},
+	})
+
+	body := `{"web:10.0.0.1":{"normal":2,"danger":1},"web:10.0.0.2":{"normal":2,"danger":1}}`
+	resp, err := http.Post(server.URL+"/log/bulk", "application/json", strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("POST /log/bulk responded %d", resp.StatusCode)
+	}
+	v.snapshot()
+
+	for key, want := range map[string]Metrics{
+		"web:10.0.0.1": {Normal: 20 * observationWeight, Danger: 10 * observationWeight},
+		"web:10.0.0.2": {Normal: 2 * observationWeight, Danger: 1 * observationWeight},
+	} {
+		if got := v.ConnectionMap.connections[key].Metrics; !got.equal(want) {
+			t.Errorf("%s metrics = %+v, want %+v", key, got, want)
+		}
+	}
+}
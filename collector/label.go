@@ -0,0 +1,61 @@
+package collector
+
+import (
+	"bytes"
+	"log"
+	"text/template"
+)
+
+// labelData is what a LabelTemplate is executed with for a connection
+// RPS is its requests per second and ErrorPercent its error rate in
+// percent, Latency is nil when no latency was reported
+type labelData struct {
+	Source       string
+	Target       string
+	Class        string
+	Metrics      Metrics
+	Rate         *Rate
+	RPS          float64
+	ErrorRate    float64
+	ErrorPercent float64
+	WarnRate     float64
+	Latency      *Latency
+}
+
+// compileLabel parses a LabelTemplate, nil when it is empty
+func compileLabel(text string) (*template.Template, error) {
+	if text == "" {
+		return nil, nil
+	}
+	return template.New("label").Parse(text)
+}
+
+// label sets the label of a connection from the LabelTemplate, it is
+// called once the connection is classified and again on the copies of
+// the display transforms renaming connections, so labels show the
+// names that are served
+func (v *Vizceral) label(con *VizceralConnection) {
+	if v.labelTemplate == nil {
+		return
+	}
+	rate := con.Metrics.rate(con.window())
+	data := labelData{
+		Source:       con.Source,
+		Target:       con.Target,
+		Class:        con.Class,
+		Metrics:      con.Metrics,
+		Rate:         rate,
+		RPS:          float64(con.Metrics.observations()) / con.window().Seconds(),
+		ErrorRate:    con.ErrorRate,
+		ErrorPercent: con.ErrorRate * 100,
+		WarnRate:     con.WarnRate,
+		Latency:      con.Latency,
+	}
+	var buf bytes.Buffer
+	if err := v.labelTemplate.Execute(&buf, data); err != nil {
+		log.Printf("warning: label of %s failed: %v", v.key(con.Source, con.Target), err)
+		con.Label = ""
+		return
+	}
+	con.Label = buf.String()
+}
@@ -0,0 +1,121 @@
+package collector
+
+import (
+	"math/rand"
+	"sort"
+)
+
+// maxLatencySamples bounds how many latency samples a connection keeps
+// per window, beyond that reservoir sampling is used
+const maxLatencySamples = 1024
+
+// Latency holds the latency percentiles in milliseconds of a window
+type Latency struct {
+	P50 float64 `json:"p50"`
+	P95 float64 `json:"p95"`
+	P99 float64 `json:"p99"`
+}
+
+// latencySamples accumulates the latency samples of a window
+type latencySamples struct {
+	samples []float64
+	seen    int
+}
+
+func (l *latencySamples) add(ms float64) {
+	l.seen++
+	if len(l.samples) < maxLatencySamples {
+		l.samples = append(l.samples, ms)
+		return
+	}
+	if i := rand.Intn(l.seen); i < maxLatencySamples {
+		l.samples[i] = ms
+	}
+}
+
+// latency returns the percentiles of the samples, or nil when
+// no samples were recorded
+func (l *latencySamples) latency() *Latency {
+	if len(l.samples) == 0 {
+		return nil
+	}
+	sorted := append([]float64(nil), l.samples...)
+	sort.Float64s(sorted)
+	return &Latency{
+		P50: percentile(sorted, 50),
+		P95: percentile(sorted, 95),
+		P99: percentile(sorted, 99),
+	}
+}
+
+// percentile returns the nearest rank percentile p of sorted values
+func percentile(sorted []float64, p float64) float64 {
+	i := int(float64(len(sorted))*p/100+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return sorted[i]
+}
+
+// LatencyThresholds are the p95 latencies in milliseconds at which
+// a connection becomes warning or danger. A threshold of 0 is disabled
+type LatencyThresholds struct {
+	WarnMs   float64 `yaml:"warnMs" json:"warnMs"`
+	DangerMs float64 `yaml:"dangerMs" json:"dangerMs"`
+}
+
+func (t LatencyThresholds) classOf(l *Latency) string {
+	if t.WarnMs == 0 && t.DangerMs == 0 {
+		return ""
+	}
+	if l == nil {
+		return classNormal
+	}
+	switch {
+	case t.DangerMs > 0 && l.P95 >= t.DangerMs:
+		return classDanger
+	case t.WarnMs > 0 && l.P95 >= t.WarnMs:
+		return classWarning
+	}
+	return classNormal
+}
+
+// classRank orders the classes by severity
+var classRank = map[string]int{
+	"":           0,
+	classNormal:  1,
+	classWarning: 2,
+	classDanger:  3,
+}
+
+// worse returns the more severe of two classes
+func worse(a, b string) string {
+	if classRank[b] > classRank[a] {
+		return b
+	}
+	return a
+}
+
+// LatencySLO splits the requests of a window logged with a latency
+// into those Within the latency SLO of their connection and those
+// Over it, and Attainment is the fraction that were within
+type LatencySLO struct {
+	Within     int     `json:"within"`
+	Over       int     `json:"over"`
+	Attainment float64 `json:"attainment"`
+}
+
+// add counts a request of ms against an SLO of sloMs, nothing is
+// counted when there is no SLO
+func (s *LatencySLO) add(ms, sloMs float64) {
+	switch {
+	case sloMs <= 0:
+	case ms <= sloMs:
+		s.Within++
+	default:
+		s.Over++
+	}
+}
@@ -0,0 +1,145 @@
+package collector
+
+import (
+	"hash/fnv"
+	"log"
+	"sync"
+	"sync/atomic"
+)
+
+// shard is a mutex guarding the metrics of connections, counting how
+// often it was taken and how often it had to be waited for
+type shard struct {
+	sync.Mutex
+	acquired  uint64
+	contended uint64
+}
+
+// lock acquires the shard, reporting whether it had to wait
+func (s *shard) lock() bool {
+	atomic.AddUint64(&s.acquired, 1)
+	if s.TryLock() {
+		return false
+	}
+	atomic.AddUint64(&s.contended, 1)
+	s.Lock()
+	return true
+}
+
+// lockSlot holds the shard guarding one connection, which is replaced
+// by a shard of its own when the connection is promoted by HotLocks.
+// contended counts the waits of the connection since the last snapshot
+type lockSlot struct {
+	current   atomic.Pointer[shard]
+	contended uint64
+	dedicated bool
+}
+
+// lock acquires the shard of the connection and returns it. The shard
+// is only replaced while it is held, so one that is no longer current
+// once acquired is released and the new one taken instead
+func (l *lockSlot) lock() *shard {
+	for {
+		s := l.current.Load()
+		if s.lock() {
+			atomic.AddUint64(&l.contended, 1)
+		}
+		if l.current.Load() == s {
+			return s
+		}
+		s.Unlock()
+	}
+}
+
+// Lock and Unlock guard the connection
+func (l *lockSlot) Lock()   { l.lock() }
+func (l *lockSlot) Unlock() { l.current.Load().Unlock() }
+
+// createLocks allocates the lock shards used to guard connections,
+// a single one when shards is 0
+func (v *Vizceral) createLocks(shards int) {
+	if shards < 1 {
+		shards = 1
+	}
+	v.locks = nil
+	for i := 0; i < shards; i++ {
+		v.locks = append(v.locks, &shard{})
+	}
+}
+
+// lockFor returns the lock guarding the connection with the given key
+func (v *Vizceral) lockFor(connectionHash string) *lockSlot {
+	h := fnv.New32a()
+	h.Write([]byte(connectionHash))
+	l := &lockSlot{}
+	l.current.Store(v.locks[h.Sum32()%uint32(len(v.locks))])
+	return l
+}
+
+// lockSwap releases held and acquires the lock of next unless it is
+// already held, returning the shard now held. next may be nil to only
+// release held
+func lockSwap(held *shard, next *lockSlot) *shard {
+	if next != nil && next.current.Load() == held {
+		return held
+	}
+	if held != nil {
+		held.Unlock()
+	}
+	if next == nil {
+		return nil
+	}
+	return next.lock()
+}
+
+// HotLocksConfig gives a connection whose shard was waited for at
+// least Contention times in one snapshot interval a lock of its own,
+// so a hot connection stops slowing down the others of its shard. At
+// most Max connections are promoted, defaulting to 16. It is disabled
+// when Contention is 0
+type HotLocksConfig struct {
+	Contention int `yaml:"contention" json:"contention"`
+	Max        int `yaml:"max" json:"max"`
+}
+
+func (c HotLocksConfig) max() int {
+	if c.Max <= 0 {
+		return 16
+	}
+	return c.Max
+}
+
+// promoteHotLocks gives the connections contended beyond HotLocks a
+// lock of their own and resets the contention of the others, the
+// caller must hold graphMu exclusively
+func (v *Vizceral) promoteHotLocks() {
+	conf := v.config.HotLocks
+	for _, con := range v.allConnections() {
+		contended := atomic.SwapUint64(&con.mu.contended, 0)
+		if conf.Contention <= 0 || con.mu.dedicated || contended < uint64(conf.Contention) || atomic.LoadInt64(&v.dedicatedLocks) >= int64(conf.max()) {
+			continue
+		}
+		held := con.mu.lock()
+		dedicated := &shard{}
+		con.mu.current.Store(dedicated)
+		held.Unlock()
+		con.mu.dedicated = true
+		atomic.AddInt64(&v.dedicatedLocks, 1)
+		log.Printf("gave connection %s a lock of its own after %d contended locks", v.key(con.Source, con.Target), contended)
+	}
+}
+
+// lockStats holds the counts of one lock shard
+type lockStats struct {
+	Acquired  uint64 `json:"acquired"`
+	Contended uint64 `json:"contended"`
+}
+
+// shardStats returns the counts of every lock shard, in order
+func (v *Vizceral) shardStats() []lockStats {
+	stats := make([]lockStats, len(v.locks))
+	for i, s := range v.locks {
+		stats[i] = lockStats{atomic.LoadUint64(&s.acquired), atomic.LoadUint64(&s.contended)}
+	}
+	return stats
+}
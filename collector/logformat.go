@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+)
+
+// Log formats accepted by SetLogFormat
+const (
+	logFormatText = "text"
+	logFormatJSON = "json"
+)
+
+// SetLogFormat selects how the standard logger, used throughout cargo,
+// writes its lines: text (default) as they are, or json as one object
+// per line with the time, level and message. Messages starting with
+// "warning: " have level warning, the others info
+func SetLogFormat(format string) error {
+	switch format {
+	case "", logFormatText:
+		return nil
+	case logFormatJSON:
+		log.SetFlags(0)
+		log.SetOutput(&jsonLogWriter{out: os.Stderr})
+		return nil
+	}
+	return fmt.Errorf("unknown log format %q, expected text or json", format)
+}
+
+// jsonLogWriter turns the lines of the standard logger into JSON
+type jsonLogWriter struct {
+	out io.Writer
+}
+
+type jsonLogLine struct {
+	Time    string `json:"time"`
+	Level   string `json:"level"`
+	Message string `json:"msg"`
+}
+
+func (w *jsonLogWriter) Write(p []byte) (int, error) {
+	line := jsonLogLine{
+		Time:    time.Now().UTC().Format(time.RFC3339Nano),
+		Level:   "info",
+		Message: strings.TrimSuffix(string(p), "\n"),
+	}
+	if strings.HasPrefix(line.Message, "warning: ") {
+		line.Level = "warning"
+		line.Message = strings.TrimPrefix(line.Message, "warning: ")
+	}
+	data, err := json.Marshal(line)
+	if err != nil {
+		return 0, err
+	}
+	if _, err := w.out.Write(append(data, '\n')); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
@@ -0,0 +1,157 @@
+package collector
+
+import (
+	"bytes"
+	"encoding/json"
+	"sort"
+	"strconv"
+	"time"
+)
+
+// defaultBuckets are the buckets used when none are configured,
+// they share their names with the classes
+var defaultBuckets = []string{classNormal, classWarning, classDanger}
+
+// Metrics holds the count of traffic split into buckets
+// Custom holds the counts of configured buckets beyond the standard
+// three, they are flattened alongside them in the JSON output
+type Metrics struct {
+	Normal  int            `json:"normal"`
+	Danger  int            `json:"danger"`
+	Warning int            `json:"warning"`
+	Custom  map[string]int `json:"-"`
+}
+
+// Sum returns the total count of observations for a set of Metrics
+func (m Metrics) Sum() int {
+	sum := m.Normal + m.Warning + m.Danger
+	for _, n := range m.Custom {
+		sum += n
+	}
+	return sum
+}
+
+// observations returns the number of logged requests in the Metrics
+func (m Metrics) observations() int {
+	return m.Sum() / observationWeight
+}
+
+// Rate holds the requests per second of each bucket
+type Rate struct {
+	Normal  float64            `json:"normal"`
+	Danger  float64            `json:"danger"`
+	Warning float64            `json:"warning"`
+	Custom  map[string]float64 `json:"custom,omitempty"`
+}
+
+// rate returns the requests per second of metrics covering window
+func (m Metrics) rate(window time.Duration) *Rate {
+	perSecond := func(n int) float64 {
+		return float64(n) / observationWeight / window.Seconds()
+	}
+	r := &Rate{Normal: perSecond(m.Normal), Danger: perSecond(m.Danger), Warning: perSecond(m.Warning)}
+	for name, n := range m.Custom {
+		if r.Custom == nil {
+			r.Custom = make(map[string]float64)
+		}
+		r.Custom[name] = perSecond(n)
+	}
+	return r
+}
+
+// equal reports whether m and o hold the same counts
+func (m Metrics) equal(o Metrics) bool {
+	if m.Normal != o.Normal || m.Warning != o.Warning || m.Danger != o.Danger || len(m.Custom) != len(o.Custom) {
+		return false
+	}
+	for name, n := range m.Custom {
+		if o.Custom[name] != n {
+			return false
+		}
+	}
+	return true
+}
+
+// scaled returns the metrics with every bucket multiplied by num/den
+func (m Metrics) scaled(num, den int) Metrics {
+	scale := func(n int) int { return int(int64(n) * int64(num) / int64(den)) }
+	out := Metrics{Normal: scale(m.Normal), Danger: scale(m.Danger), Warning: scale(m.Warning)}
+	for name, n := range m.Custom {
+		out.addBucket(name, scale(n))
+	}
+	return out
+}
+
+// errorRate returns the fraction of observations in danger, 0 when empty
+func (m Metrics) errorRate() float64 {
+	total := m.Sum()
+	if total == 0 {
+		return 0
+	}
+	return float64(m.Danger) / float64(total)
+}
+
+// warnRate returns the fraction of observations in warning, 0 when empty
+func (m Metrics) warnRate() float64 {
+	total := m.Sum()
+	if total == 0 {
+		return 0
+	}
+	return float64(m.Warning) / float64(total)
+}
+
+// Add accumulates another set of Metrics into this one
+func (m *Metrics) Add(o Metrics) {
+	m.Normal += o.Normal
+	m.Warning += o.Warning
+	m.Danger += o.Danger
+	for bucket, n := range o.Custom {
+		m.addBucket(bucket, n)
+	}
+}
+
+// addBucket adds n to the named bucket
+func (m *Metrics) addBucket(bucket string, n int) {
+	switch bucket {
+	case classNormal:
+		m.Normal += n
+	case classWarning:
+		m.Warning += n
+	case classDanger:
+		m.Danger += n
+	default:
+		if m.Custom == nil {
+			m.Custom = make(map[string]int)
+		}
+		m.Custom[bucket] += n
+	}
+}
+
+// MarshalJSON flattens the standard and custom buckets into one object
+func (m Metrics) MarshalJSON() ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(`{"normal":`)
+	buf.WriteString(strconv.Itoa(m.Normal))
+	buf.WriteString(`,"danger":`)
+	buf.WriteString(strconv.Itoa(m.Danger))
+	buf.WriteString(`,"warning":`)
+	buf.WriteString(strconv.Itoa(m.Warning))
+
+	buckets := make([]string, 0, len(m.Custom))
+	for bucket := range m.Custom {
+		buckets = append(buckets, bucket)
+	}
+	sort.Strings(buckets)
+	for _, bucket := range buckets {
+		name, err := json.Marshal(bucket)
+		if err != nil {
+			return nil, err
+		}
+		buf.WriteByte(',')
+		buf.Write(name)
+		buf.WriteByte(':')
+		buf.WriteString(strconv.Itoa(m.Custom[bucket]))
+	}
+	buf.WriteByte('}')
+	return buf.Bytes(), nil
+}
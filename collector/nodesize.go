@@ -0,0 +1,53 @@
+package collector
+
+// NodeSizingConfig sizes nodes by their health as well as their
+// traffic, so a quiet but failing node does not go unnoticed. Enabled
+// sets the MaxVolume of every node, summed over the connections into
+// and out of it, to VolumeWeight times their volume plus ErrorWeight
+// times their error rate times the MaxVolume of the graph. VolumeWeight
+// defaults to 1 and ErrorWeight to 0.5. Tiers with services keep the
+// MaxVolume of their nested graph
+type NodeSizingConfig struct {
+	Enabled      bool    `yaml:"enabled" json:"enabled"`
+	VolumeWeight float64 `yaml:"volumeWeight" json:"volumeWeight"`
+	ErrorWeight  float64 `yaml:"errorWeight" json:"errorWeight"`
+}
+
+func (c NodeSizingConfig) volumeWeight() float64 {
+	if c.VolumeWeight <= 0 {
+		return 1
+	}
+	return c.VolumeWeight
+}
+
+func (c NodeSizingConfig) errorWeight() float64 {
+	if c.ErrorWeight <= 0 {
+		return 0.5
+	}
+	return c.ErrorWeight
+}
+
+// sizeNodes sets the MaxVolume of the nodes from their traffic and
+// error rate, see NodeSizingConfig
+func (v *Vizceral) sizeNodes() {
+	sizing := v.config.NodeSizing
+	if !sizing.Enabled {
+		return
+	}
+	totals := make(map[string]Metrics)
+	for _, con := range v.ConnectionMap.connections {
+		for _, name := range []string{con.Source, con.Target} {
+			m := totals[name]
+			m.Add(v.classMetrics(con))
+			totals[name] = m
+		}
+	}
+	for name, node := range v.NodeMap.nodes {
+		if node.Nodes != nil {
+			continue
+		}
+		m := totals[name]
+		size := sizing.volumeWeight()*float64(m.Sum()) + sizing.errorWeight()*m.errorRate()*float64(v.MaxVolume)
+		node.MaxVolume = int(size)
+	}
+}
@@ -0,0 +1,103 @@
+package collector
+
+import (
+	"sort"
+	"sync/atomic"
+	"time"
+)
+
+// heldNotice is the worst automatic notice of a connection since it
+// was last shown without one, with until the time it may be cleared
+// rank orders notices of the same severity, such as the length of
+// the danger streak they describe
+type heldNotice struct {
+	notice *Notice
+	rank   int
+	until  time.Time
+}
+
+// worse reports whether a notice outranks the held one
+func (h heldNotice) worse(notice *Notice, rank int) bool {
+	if h.notice == nil {
+		return true
+	}
+	if notice.Severity != h.notice.Severity {
+		return notice.Severity > h.notice.Severity
+	}
+	return rank >= h.rank
+}
+
+// showNotice sets the automatic notice of a connection, nil when its
+// condition is clear. With NoticeHold a shown notice is kept until
+// the condition has been clear for the hold, and is only replaced by
+// a worse one meanwhile
+func (v *Vizceral) showNotice(con *VizceralConnection, notice *Notice, rank int) {
+	hold := v.config.NoticeHold
+	if hold <= 0 {
+		con.notice = heldNotice{}
+		if notice == nil {
+			con.Notices = nil
+			return
+		}
+		con.Notices = []Notice{*notice}
+		return
+	}
+	now := time.Now()
+	if notice != nil {
+		if con.notice.worse(notice, rank) {
+			con.notice.notice, con.notice.rank = notice, rank
+		}
+		con.notice.until = now.Add(hold)
+	} else if con.notice.notice != nil && !now.Before(con.notice.until) {
+		con.notice = heldNotice{}
+	}
+	if con.notice.notice == nil {
+		con.Notices = nil
+		return
+	}
+	con.Notices = []Notice{*con.notice.notice}
+}
+
+// capNotices keeps the max most severe of notices, the earliest of
+// equally severe ones, in their original order, and returns them
+// along with the number dropped
+func capNotices(notices []Notice, max int) ([]Notice, int) {
+	if max <= 0 || len(notices) <= max {
+		return notices, 0
+	}
+	order := make([]int, len(notices))
+	for i := range order {
+		order[i] = i
+	}
+	sort.SliceStable(order, func(a, b int) bool {
+		return notices[order[a]].Severity > notices[order[b]].Severity
+	})
+	keep := order[:max]
+	sort.Ints(keep)
+	kept := make([]Notice, 0, max)
+	for _, i := range keep {
+		kept = append(kept, notices[i])
+	}
+	return kept, len(notices) - max
+}
+
+// limitNotices applies MaxNotices to every node and connection after
+// a snapshot, counting the notices dropped
+func (v *Vizceral) limitNotices() {
+	max := v.config.MaxNotices
+	if max <= 0 {
+		return
+	}
+	dropped := 0
+	for _, con := range v.ConnectionMap.connections {
+		var n int
+		con.Notices, n = capNotices(con.Notices, max)
+		dropped += n
+	}
+	for _, node := range v.NodeMap.nodes {
+		var n int
+		node.Notices, n = capNotices(node.Notices, max)
+		dropped += n
+	}
+	atomic.AddUint64(&v.suppressedNotices, uint64(dropped))
+}
@@ -0,0 +1,81 @@
+package collector
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"strings"
+)
+
+// EntryOrigin is a node splitting the entry traffic coming from
+// Networks, IPs or CIDRs such as 10.0.0.0/8 for internal clients
+type EntryOrigin struct {
+	Name     string   `yaml:"name" json:"name"`
+	Networks []string `yaml:"networks" json:"networks"`
+}
+
+// compiledOrigin is an EntryOrigin with its networks parsed
+type compiledOrigin struct {
+	name     string
+	networks trustedProxies
+}
+
+// compileOrigins parses the networks of the entry origins
+func compileOrigins(origins []EntryOrigin) ([]compiledOrigin, error) {
+	var compiled []compiledOrigin
+	for _, origin := range origins {
+		if origin.Name == "" {
+			return nil, fmt.Errorf("an origin has no name")
+		}
+		networks, err := parseTrustedProxies(origin.Networks)
+		if err != nil {
+			return nil, fmt.Errorf("origin %s: %v", origin.Name, err)
+		}
+		compiled = append(compiled, compiledOrigin{origin.Name, networks})
+	}
+	return compiled, nil
+}
+
+// createOrigins adds a node for every entry origin, with connections
+// into the same tiers as the entry node
+func (v *Vizceral) createOrigins() {
+	entry := v.config.Entry
+	for _, origin := range v.origins {
+		v.addNode(origin.name, entry.renderer())
+		log.Printf("created entry origin %s", origin.name)
+		for _, target := range entry.Targets {
+			v.addConnection(origin.name, target)
+		}
+	}
+}
+
+// originConnection returns the key logged for connection when it is
+// one of the entry node's, attributing it to the first origin whose
+// networks contain the client address origin. Unmatched and missing
+// addresses stay with the entry node
+func (v *Vizceral) originConnection(connection, origin string) string {
+	if len(v.origins) == 0 || origin == "" {
+		return connection
+	}
+	parts := strings.SplitN(connection, v.config.keySeparator(), 2)
+	ip := net.ParseIP(origin)
+	if len(parts) != 2 || parts[0] != v.EntryNode || ip == nil {
+		return connection
+	}
+	for _, o := range v.origins {
+		if o.networks.contains(ip) {
+			return v.key(o.name, parts[1])
+		}
+	}
+	return connection
+}
+
+// isOrigin reports whether name is the node of an entry origin
+func (v *Vizceral) isOrigin(name string) bool {
+	for _, o := range v.origins {
+		if o.name == name {
+			return true
+		}
+	}
+	return false
+}
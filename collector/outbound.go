@@ -0,0 +1,387 @@
+package collector
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// OutboundConfig configures the HTTP client shared by every outbound
+// integration. Timeout bounds each attempt and defaults to 5s.
+// Retries defaults to 2, a negative value disables retrying.
+// Backoff is the wait before the first retry, doubled on every
+// following retry, and defaults to 1s
+// Workers is the number of requests sent at once, defaulting to 4, so
+// a slow integration cannot hold up the others. Queue bounds the
+// requests waiting for a worker, defaulting to 100, beyond which they
+// are dropped. Deadline bounds a request along with its retries,
+// defaulting to 30s
+// RetryBuffer is the number of failed requests kept per integration
+// to be sent again after later snapshots, the oldest being dropped
+// when it is full. 0 disables it
+type OutboundConfig struct {
+	Timeout     time.Duration `yaml:"timeout" json:"timeout"`
+	Retries     int           `yaml:"retries" json:"retries"`
+	Backoff     time.Duration `yaml:"backoff" json:"backoff"`
+	Workers     int           `yaml:"workers" json:"workers"`
+	Queue       int           `yaml:"queue" json:"queue"`
+	Deadline    time.Duration `yaml:"deadline" json:"deadline"`
+	RetryBuffer int           `yaml:"retryBuffer" json:"retryBuffer"`
+}
+
+// outboundClient sends requests to outbound integrations with
+// a bounded number of retries
+type outboundClient struct {
+	client   *http.Client
+	retries  int
+	backoff  time.Duration
+	deadline time.Duration
+
+	// jobs are the requests waiting for a worker
+	jobs chan outboundJob
+
+	// pending tracks the requests sent in the background
+	pending sync.WaitGroup
+
+	// results counts the outcome of the requests of each integration
+	// and buffers holds their failed requests, both guarded by mu
+	mu         sync.Mutex
+	results    map[string]*outputStats
+	buffers    map[string]*retryBuffer
+	bufferSize int
+
+	// seq numbers the requests made and keys holds the ordering of
+	// the keys with requests queued or being sent, both guarded by mu
+	seq  uint64
+	keys map[string]*keyOrder
+}
+
+// keyOrder serializes the requests of one key of an integration.
+// latest is the newest request made for it and jobs the number of its
+// requests queued or being sent
+type keyOrder struct {
+	mu     sync.Mutex
+	latest uint64
+	jobs   int
+}
+
+// outboundJob is a request queued for a worker
+type outboundJob struct {
+	name        string
+	url         string
+	contentType string
+	body        []byte
+
+	// retried is set for requests sent again from the retry buffer
+	retried bool
+
+	// key orders the requests of an integration that must not
+	// overtake each other, such as the events of one PagerDuty
+	// incident, and seq is the order they were made in. Once a newer
+	// request with the same key is made an older one is dropped
+	// rather than sent. Requests without a key are not ordered
+	key string
+	seq uint64
+}
+
+// outputStats counts the requests of one integration
+// Buffered is the depth of its retry buffer and BufferDropped the
+// failed requests dropped from the full buffer. Superseded counts the
+// requests dropped for a newer one with the same key
+type outputStats struct {
+	Sent          uint64 `json:"sent"`
+	Failed        uint64 `json:"failed"`
+	Dropped       uint64 `json:"dropped"`
+	Buffered      int    `json:"buffered"`
+	BufferDropped uint64 `json:"bufferDropped"`
+	Superseded    uint64 `json:"superseded"`
+}
+
+func newOutboundClient(c OutboundConfig) *outboundClient {
+	timeout := c.Timeout
+	if timeout <= 0 {
+		timeout = 5 * time.Second
+	}
+	retries := c.Retries
+	if retries == 0 {
+		retries = 2
+	} else if retries < 0 {
+		retries = 0
+	}
+	backoff := c.Backoff
+	if backoff <= 0 {
+		backoff = time.Second
+	}
+	workers := c.Workers
+	if workers <= 0 {
+		workers = 4
+	}
+	queue := c.Queue
+	if queue <= 0 {
+		queue = 100
+	}
+	deadline := c.Deadline
+	if deadline <= 0 {
+		deadline = 30 * time.Second
+	}
+	oc := &outboundClient{
+		client:   &http.Client{Timeout: timeout},
+		retries:  retries,
+		backoff:  backoff,
+		deadline: deadline,
+		jobs:     make(chan outboundJob, queue),
+		results:  make(map[string]*outputStats),
+		buffers:  make(map[string]*retryBuffer),
+		keys:     make(map[string]*keyOrder),
+
+		bufferSize: c.RetryBuffer,
+	}
+	for i := 0; i < workers; i++ {
+		go oc.work()
+	}
+	return oc
+}
+
+// postAsync queues body to be posted to url by a worker, logging
+// failures under the name of the integration. A request is dropped
+// rather than waiting when the queue is full
+func (c *outboundClient) postAsync(name, url, contentType string, body []byte) {
+	c.postKeyed(name, "", url, contentType, body)
+}
+
+// postKeyed is postAsync for a request ordered by key, see outboundJob
+func (c *outboundClient) postKeyed(name, key, url, contentType string, body []byte) {
+	job := outboundJob{name: name, url: url, contentType: contentType, body: body, key: key}
+	c.mu.Lock()
+	c.seq++
+	job.seq = c.seq
+	c.mu.Unlock()
+	if !c.enqueue(job) {
+		c.count(name, func(s *outputStats) { s.Dropped++ })
+		log.Printf("%s dropped a request, the outbound queue is full", name)
+	}
+}
+
+// enqueue queues a request for a worker unless the queue is full,
+// reporting whether it was queued
+func (c *outboundClient) enqueue(job outboundJob) bool {
+	c.pending.Add(1)
+	c.track(job)
+	select {
+	case c.jobs <- job:
+		return true
+	default:
+		c.untrack(job)
+		c.pending.Done()
+		return false
+	}
+}
+
+// track adds a queued request to the ordering of its key, making it
+// the latest when it is the newest, and drops the buffered requests it
+// supersedes
+func (c *outboundClient) track(job outboundJob) {
+	if job.key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := job.name + "\x00" + job.key
+	order, ok := c.keys[id]
+	if !ok {
+		order = &keyOrder{}
+		c.keys[id] = order
+	}
+	order.jobs++
+	if job.seq <= order.latest {
+		return
+	}
+	order.latest = job.seq
+	if buffer, ok := c.buffers[job.name]; ok {
+		kept := buffer.jobs[:0]
+		for _, buffered := range buffer.jobs {
+			if buffered.key == job.key {
+				c.resultsOf(job.name).Superseded++
+				continue
+			}
+			kept = append(kept, buffered)
+		}
+		buffer.jobs = kept
+	}
+}
+
+// untrack removes a request that is done from the ordering of its key
+func (c *outboundClient) untrack(job outboundJob) {
+	if job.key == "" {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	id := job.name + "\x00" + job.key
+	if order := c.keys[id]; order != nil {
+		if order.jobs--; order.jobs == 0 {
+			delete(c.keys, id)
+		}
+	}
+}
+
+// order returns the ordering of the key of a queued request, nil when
+// it has no key
+func (c *outboundClient) order(job outboundJob) *keyOrder {
+	if job.key == "" {
+		return nil
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.keys[job.name+"\x00"+job.key]
+}
+
+// superseded reports whether a newer request was made with the key
+// of job, counting it when it was
+func (c *outboundClient) superseded(job outboundJob, order *keyOrder) bool {
+	if order == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if job.seq >= order.latest {
+		return false
+	}
+	c.resultsOf(job.name).Superseded++
+	return true
+}
+
+// work sends queued requests for the life of the collector. The
+// requests of one key are sent one at a time, so a request is never
+// delivered after a newer one with the same key
+func (c *outboundClient) work() {
+	for job := range c.jobs {
+		order := c.order(job)
+		if order != nil {
+			order.mu.Lock()
+		}
+		c.send(job, order)
+		if order != nil {
+			order.mu.Unlock()
+		}
+		c.untrack(job)
+		c.pending.Done()
+	}
+}
+
+// send posts a queued request unless it was superseded, buffering it
+// for a retry when it fails
+func (c *outboundClient) send(job outboundJob, order *keyOrder) {
+	if c.superseded(job, order) {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), c.deadline)
+	err := c.post(ctx, job.url, job.contentType, job.body)
+	cancel()
+	if err != nil {
+		c.count(job.name, func(s *outputStats) { s.Failed++ })
+		log.Printf("%s failed: %v", job.name, err)
+		if _, permanent := err.(permanentError); !permanent && !c.superseded(job, order) {
+			c.buffer(job)
+		}
+		return
+	}
+	c.count(job.name, func(s *outputStats) { s.Sent++ })
+	if job.retried {
+		c.recovered(job.name)
+	}
+}
+
+// count updates the counters of an integration
+func (c *outboundClient) count(name string, update func(*outputStats)) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	update(c.resultsOf(name))
+}
+
+// resultsOf returns the counters of an integration, the caller must
+// hold mu
+func (c *outboundClient) resultsOf(name string) *outputStats {
+	stats, ok := c.results[name]
+	if !ok {
+		stats = &outputStats{}
+		c.results[name] = stats
+	}
+	return stats
+}
+
+// stats returns a copy of the counters of every integration
+func (c *outboundClient) stats() map[string]outputStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	out := make(map[string]outputStats, len(c.results))
+	for name, stats := range c.results {
+		s := *stats
+		if buffer, ok := c.buffers[name]; ok {
+			s.Buffered = len(buffer.jobs)
+		}
+		out[name] = s
+	}
+	return out
+}
+
+// wait blocks until the background requests are done or timeout
+// elapses, and reports whether they all completed
+func (c *outboundClient) wait(timeout time.Duration) bool {
+	done := make(chan struct{})
+	go func() {
+		c.pending.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return true
+	case <-time.After(timeout):
+		return false
+	}
+}
+
+// post sends body to url, retrying on errors and 5xx responses
+// until ctx is done
+func (c *outboundClient) post(ctx context.Context, url, contentType string, body []byte) error {
+	var err error
+	backoff := c.backoff
+	for attempt := 0; attempt <= c.retries; attempt++ {
+		if attempt > 0 {
+			select {
+			case <-time.After(backoff):
+			case <-ctx.Done():
+				return fmt.Errorf("%s: %v after %d attempts, last error: %v", url, ctx.Err(), attempt, err)
+			}
+			backoff *= 2
+		}
+		var req *http.Request
+		req, err = http.NewRequest(http.MethodPost, url, bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", contentType)
+		var resp *http.Response
+		resp, err = c.client.Do(req.WithContext(ctx))
+		if err != nil {
+			continue
+		}
+		io.Copy(ioutil.Discard, resp.Body)
+		resp.Body.Close()
+		if resp.StatusCode >= 500 {
+			err = fmt.Errorf("%s responded %s", url, resp.Status)
+			continue
+		}
+		if resp.StatusCode >= 400 {
+			return permanentError{fmt.Errorf("%s responded %s", url, resp.Status)}
+		}
+		return nil
+	}
+	return err
+}
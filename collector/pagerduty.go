@@ -0,0 +1,119 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"time"
+)
+
+// PagerDutyConfig triggers PagerDuty incidents for connections
+// RoutingKey is the integration key of the PagerDuty service, an empty
+// key disables the integration. Severities maps the classes that
+// trigger an incident to their PagerDuty severity, defaulting to
+// danger: critical. An incident is resolved once its connection is
+// back in a class without severity. Debounce is the number of
+// consecutive snapshots a connection must spend in such a class
+// before triggering, defaulting to 1. URL defaults to the Events API v2.
+// The routing key and URL are left out of /config
+type PagerDutyConfig struct {
+	RoutingKey string            `yaml:"routingKey" json:"-"`
+	Severities map[string]string `yaml:"severities" json:"severities"`
+	Debounce   int               `yaml:"debounce" json:"debounce"`
+	URL        string            `yaml:"url" json:"-"`
+}
+
+func (c PagerDutyConfig) url() string {
+	if c.URL == "" {
+		return "https://events.pagerduty.com/v2/enqueue"
+	}
+	return c.URL
+}
+
+func (c PagerDutyConfig) severity(class string) string {
+	if len(c.Severities) == 0 {
+		if class == classDanger {
+			return "critical"
+		}
+		return ""
+	}
+	return c.Severities[class]
+}
+
+func (c PagerDutyConfig) debounce() int {
+	if c.Debounce <= 0 {
+		return 1
+	}
+	return c.Debounce
+}
+
+// pagerDutyEvent is a PagerDuty Events API v2 event
+type pagerDutyEvent struct {
+	RoutingKey  string            `json:"routing_key"`
+	EventAction string            `json:"event_action"`
+	DedupKey    string            `json:"dedup_key"`
+	Payload     *pagerDutyPayload `json:"payload,omitempty"`
+}
+
+type pagerDutyPayload struct {
+	Summary       string      `json:"summary"`
+	Source        string      `json:"source"`
+	Severity      string      `json:"severity"`
+	CustomDetails interface{} `json:"custom_details,omitempty"`
+}
+
+// pagerDuty triggers or resolves the incident of a connection from
+// its class, called after every classification. Nothing is triggered
+// during the Warmup or for connections with suppressed alerts
+func (v *Vizceral) pagerDuty(con *VizceralConnection) {
+	pd := v.config.PagerDuty
+	if pd.RoutingKey == "" {
+		return
+	}
+	severity := pd.severity(con.class)
+	if severity == "" {
+		con.pageStreak = 0
+		if con.paged != "" {
+			con.paged = ""
+			v.sendPagerDuty(con, "resolve", nil)
+		}
+		return
+	}
+	con.pageStreak++
+	if con.paged == severity || con.pageStreak < pd.debounce() || con.suppressAlerts {
+		return
+	}
+	if time.Since(v.started) < v.config.Warmup {
+		return
+	}
+	con.paged = severity
+	v.sendPagerDuty(con, "trigger", &pagerDutyPayload{
+		Summary:  fmt.Sprintf("connection %s is in %s (error rate %.1f%%)", v.key(con.Source, con.Target), con.class, con.ErrorRate*100),
+		Source:   "cargo",
+		Severity: severity,
+		CustomDetails: map[string]interface{}{
+			"source":  con.Source,
+			"target":  con.Target,
+			"metrics": con.Metrics,
+		},
+	})
+}
+
+// sendPagerDuty posts an event for a connection in the background
+func (v *Vizceral) sendPagerDuty(con *VizceralConnection, action string, payload *pagerDutyPayload) {
+	dedupKey := "cargo/" + v.key(con.Source, con.Target)
+	body, err := json.Marshal(pagerDutyEvent{
+		RoutingKey:  v.config.PagerDuty.RoutingKey,
+		EventAction: action,
+		DedupKey:    dedupKey,
+		Payload:     payload,
+	})
+	if err != nil {
+		log.Printf("failed to convert PagerDuty event into JSON: %v", err)
+		return
+	}
+	log.Printf("pagerduty: %s %s", action, v.key(con.Source, con.Target))
+	// the events of an incident are ordered by dedup key, so a
+	// buffered trigger is never sent after its resolve
+	v.outbound.postKeyed("pagerduty", dedupKey, v.config.PagerDuty.url(), "application/json", body)
+}
@@ -0,0 +1,137 @@
+package collector
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+	"sync/atomic"
+)
+
+// promWriter writes metrics in the Prometheus text exposition format
+type promWriter struct {
+	buf bytes.Buffer
+}
+
+// family writes the HELP and TYPE lines of a metric
+func (p *promWriter) family(name, kind, help string) {
+	fmt.Fprintf(&p.buf, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+// sample writes one value of a metric, labels alternate names and values
+func (p *promWriter) sample(name string, value float64, labels ...string) {
+	p.buf.WriteString(name)
+	if len(labels) > 0 {
+		pairs := make([]string, 0, len(labels)/2)
+		for i := 0; i+1 < len(labels); i += 2 {
+			pairs = append(pairs, fmt.Sprintf("%s=%q", labels[i], labels[i+1]))
+		}
+		p.buf.WriteString("{" + strings.Join(pairs, ",") + "}")
+	}
+	fmt.Fprintf(&p.buf, " %g\n", value)
+}
+
+// queueStats holds the state of one ingestion queue
+type queueStats struct {
+	Depth    int    `json:"depth"`
+	Capacity int    `json:"capacity"`
+	Dropped  uint64 `json:"dropped"`
+	TimedOut uint64 `json:"timedOut"`
+}
+
+// queues returns the state of the ingestion queues by name, empty
+// when ingestion is not buffered
+func (v *Vizceral) queues() map[string]queueStats {
+	if v.normalQueue == nil {
+		return map[string]queueStats{}
+	}
+	return map[string]queueStats{
+		"normal": {len(v.normalQueue), cap(v.normalQueue), atomic.LoadUint64(&v.droppedNormal), atomic.LoadUint64(&v.timedOutNormal)},
+		"danger": {len(v.dangerQueue), cap(v.dangerQueue), atomic.LoadUint64(&v.droppedDanger), atomic.LoadUint64(&v.timedOutDanger)},
+	}
+}
+
+// prometheusMetrics serves the internal counters of the collector
+// for Prometheus to scrape
+func (v *Vizceral) prometheusMetrics(w http.ResponseWriter, r *http.Request) {
+	var p promWriter
+	queues := v.queues()
+	names := make([]string, 0, len(queues))
+	for name := range queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	p.family("cargo_queue_depth", "gauge", "Increments waiting in the ingestion queue.")
+	for _, name := range names {
+		p.sample("cargo_queue_depth", float64(queues[name].Depth), "queue", name)
+	}
+	p.family("cargo_queue_capacity", "gauge", "Capacity of the ingestion queue.")
+	for _, name := range names {
+		p.sample("cargo_queue_capacity", float64(queues[name].Capacity), "queue", name)
+	}
+	p.family("cargo_queue_dropped_total", "counter", "Increments dropped as the ingestion queue was full.")
+	p.sample("cargo_queue_dropped_total", float64(atomic.LoadUint64(&v.droppedNormal)), "queue", "normal")
+	p.sample("cargo_queue_dropped_total", float64(atomic.LoadUint64(&v.droppedDanger)), "queue", "danger")
+	p.family("cargo_queue_timed_out_total", "counter", "Log requests answered 503 after waiting buffer.maxWait on a full ingestion queue.")
+	p.sample("cargo_queue_timed_out_total", float64(atomic.LoadUint64(&v.timedOutNormal)), "queue", "normal")
+	p.sample("cargo_queue_timed_out_total", float64(atomic.LoadUint64(&v.timedOutDanger)), "queue", "danger")
+
+	p.family("cargo_unknown_connections_total", "counter", "Log requests for connections that do not exist.")
+	p.sample("cargo_unknown_connections_total", float64(atomic.LoadUint64(&v.unknownRejected)), "result", "rejected")
+	p.sample("cargo_unknown_connections_total", float64(atomic.LoadUint64(&v.unknownCreated)), "result", "created")
+	p.sample("cargo_unknown_connections_total", float64(atomic.LoadUint64(&v.unknownIgnored)), "result", "ignored")
+	p.sample("cargo_unknown_connections_total", float64(atomic.LoadUint64(&v.unknownForbidden)), "result", "forbidden")
+
+	p.family("cargo_discovered_connections_total", "counter", "Connections created from the TCP connections of the host by discovery.")
+	p.sample("cargo_discovered_connections_total", float64(atomic.LoadUint64(&v.discovered)))
+
+	p.family("cargo_udp_malformed_total", "counter", "Lines of UDP datagrams dropped as malformed.")
+	p.sample("cargo_udp_malformed_total", float64(atomic.LoadUint64(&v.udpMalformed)))
+
+	p.family("cargo_evicted_connections_total", "counter", "Created connections evicted beyond maxConnections.")
+	p.sample("cargo_evicted_connections_total", float64(atomic.LoadUint64(&v.evicted)))
+
+	p.family("cargo_connections", "gauge", "Connections in the graph at the last snapshot.")
+	p.sample("cargo_connections", float64(atomic.LoadInt64(&v.connectionCount)))
+	p.family("cargo_nodes", "gauge", "Nodes in the graph at the last snapshot.")
+	p.sample("cargo_nodes", float64(atomic.LoadInt64(&v.nodeCount)))
+	if v.config.MaxConnections > 0 {
+		p.family("cargo_max_connections", "gauge", "The configured maxConnections.")
+		p.sample("cargo_max_connections", float64(v.config.MaxConnections))
+	}
+
+	p.family("cargo_suppressed_notices_total", "counter", "Notices dropped beyond maxNotices.")
+	p.sample("cargo_suppressed_notices_total", float64(atomic.LoadUint64(&v.suppressedNotices)))
+
+	outputs := v.outbound.stats()
+	names = names[:0]
+	for name := range outputs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	p.family("cargo_output_requests_total", "counter", "Requests of the outbound integrations by result.")
+	for _, name := range names {
+		p.sample("cargo_output_requests_total", float64(outputs[name].Sent), "output", name, "result", "sent")
+		p.sample("cargo_output_requests_total", float64(outputs[name].Failed), "output", name, "result", "failed")
+		p.sample("cargo_output_requests_total", float64(outputs[name].Dropped), "output", name, "result", "dropped")
+	}
+	p.family("cargo_output_retry_buffer_depth", "gauge", "Failed requests of the outbound integrations waiting to be retried.")
+	for _, name := range names {
+		p.sample("cargo_output_retry_buffer_depth", float64(outputs[name].Buffered), "output", name)
+	}
+	p.family("cargo_output_retry_buffer_dropped_total", "counter", "Failed requests dropped as the retry buffer was full.")
+	for _, name := range names {
+		p.sample("cargo_output_retry_buffer_dropped_total", float64(outputs[name].BufferDropped), "output", name)
+	}
+	p.family("cargo_output_superseded_total", "counter", "Requests dropped for a newer one with the same key.")
+	for _, name := range names {
+		p.sample("cargo_output_superseded_total", float64(outputs[name].Superseded), "output", name)
+	}
+
+	v.writeLatencyHistograms(&p)
+
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	w.Write(p.buf.Bytes())
+}
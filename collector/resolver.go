@@ -0,0 +1,91 @@
+package collector
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ReverseDNSConfig displays connection targets given as IPs by
+// their hostname. Lookups are cached for TTL, defaulting to 5m
+type ReverseDNSConfig struct {
+	Enabled bool          `yaml:"enabled" json:"enabled"`
+	TTL     time.Duration `yaml:"ttl" json:"ttl"`
+}
+
+// resolvedName is a cached reverse lookup
+type resolvedName struct {
+	name    string
+	expires time.Time
+}
+
+// resolver reverse resolves IPs in a background goroutine so that
+// neither the snapshot loop nor readers wait on DNS
+type resolver struct {
+	mu       sync.RWMutex
+	names    map[string]resolvedName
+	inflight map[string]bool
+	pending  chan string
+	ttl      time.Duration
+}
+
+func newResolver(c ReverseDNSConfig) *resolver {
+	ttl := c.TTL
+	if ttl <= 0 {
+		ttl = 5 * time.Minute
+	}
+	r := &resolver{
+		names:    make(map[string]resolvedName),
+		inflight: make(map[string]bool),
+		pending:  make(chan string, 256),
+		ttl:      ttl,
+	}
+	go r.run()
+	return r
+}
+
+// name returns the hostname for ip, falling back to ip itself while
+// the lookup is pending or when it failed. Missing and expired
+// entries are queued for resolution
+func (r *resolver) name(ip string) string {
+	if net.ParseIP(ip) == nil {
+		return ip
+	}
+	r.mu.RLock()
+	cached, ok := r.names[ip]
+	r.mu.RUnlock()
+	if !ok || time.Now().After(cached.expires) {
+		r.queue(ip)
+	}
+	if !ok {
+		return ip
+	}
+	return cached.name
+}
+
+func (r *resolver) queue(ip string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.inflight[ip] {
+		return
+	}
+	select {
+	case r.pending <- ip:
+		r.inflight[ip] = true
+	default:
+	}
+}
+
+func (r *resolver) run() {
+	for ip := range r.pending {
+		name := ip
+		if names, err := net.LookupAddr(ip); err == nil && len(names) > 0 {
+			name = strings.TrimSuffix(names[0], ".")
+		}
+		r.mu.Lock()
+		r.names[ip] = resolvedName{name: name, expires: time.Now().Add(r.ttl)}
+		delete(r.inflight, ip)
+		r.mu.Unlock()
+	}
+}
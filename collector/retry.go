@@ -0,0 +1,108 @@
+package collector
+
+import "log"
+
+// maxRetryWait is the most snapshots a retry buffer waits between
+// attempts while its integration keeps failing
+const maxRetryWait = 16
+
+// permanentError is a failure that sending again would not fix, such
+// as a 4xx response, so the request is not buffered for a retry
+type permanentError struct {
+	error
+}
+
+// retryBuffer holds the failed requests of an integration. wait is the
+// number of snapshots to skip before the next attempt, doubled on each
+// failed attempt, and skipped those skipped so far
+type retryBuffer struct {
+	jobs    []outboundJob
+	wait    int
+	skipped int
+}
+
+// buffer keeps a failed request to be sent again, dropping the oldest
+// one when the buffer of its integration is full
+func (c *outboundClient) buffer(job outboundJob) {
+	if c.bufferSize <= 0 {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	buffer, ok := c.buffers[job.name]
+	if !ok {
+		buffer = &retryBuffer{}
+		c.buffers[job.name] = buffer
+	}
+	if job.retried {
+		buffer.wait = buffer.wait*2 + 1
+		if buffer.wait > maxRetryWait {
+			buffer.wait = maxRetryWait
+		}
+	}
+	job.retried = false
+	buffer.jobs = append(buffer.jobs, job)
+	if len(buffer.jobs) > c.bufferSize {
+		buffer.jobs = buffer.jobs[1:]
+		c.results[job.name].BufferDropped++
+	}
+}
+
+// recovered resets the backoff of an integration once a retried
+// request succeeds
+func (c *outboundClient) recovered(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if buffer, ok := c.buffers[name]; ok {
+		buffer.wait = 0
+	}
+}
+
+// retry sends the buffered requests of every integration that is due,
+// it is called after each snapshot. Requests that do not fit in the
+// queue stay buffered
+func (c *outboundClient) retry() {
+	c.mu.Lock()
+	var due []outboundJob
+	for _, buffer := range c.buffers {
+		if len(buffer.jobs) == 0 {
+			continue
+		}
+		if buffer.skipped < buffer.wait {
+			buffer.skipped++
+			continue
+		}
+		buffer.skipped = 0
+		due = append(due, buffer.jobs...)
+		buffer.jobs = nil
+	}
+	c.mu.Unlock()
+
+	for i, job := range due {
+		job.retried = true
+		if !c.enqueue(job) {
+			c.requeue(due[i:])
+			log.Printf("outbound queue is full, keeping %d requests buffered", len(due)-i)
+			return
+		}
+	}
+}
+
+// requeue puts requests that could not be queued back in front of
+// their buffers, which keep the newest ones when full
+func (c *outboundClient) requeue(jobs []outboundJob) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	byName := make(map[string][]outboundJob)
+	for _, job := range jobs {
+		byName[job.name] = append(byName[job.name], job)
+	}
+	for name, left := range byName {
+		buffer := c.buffers[name]
+		buffer.jobs = append(left, buffer.jobs...)
+		if over := len(buffer.jobs) - c.bufferSize; over > 0 {
+			buffer.jobs = buffer.jobs[over:]
+			c.results[name].BufferDropped += uint64(over)
+		}
+	}
+}
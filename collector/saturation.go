@@ -0,0 +1,51 @@
+package collector
+
+// SaturationConfig holds the saturation percentages at which a tier
+// with a Ship.Capacity is shown as warning or danger, defaulting to 80
+// and 100
+type SaturationConfig struct {
+	Warning float64 `yaml:"warning" json:"warning"`
+	Danger  float64 `yaml:"danger" json:"danger"`
+}
+
+func (c SaturationConfig) warning() float64 {
+	if c.Warning <= 0 {
+		return 80
+	}
+	return c.Warning
+}
+
+func (c SaturationConfig) danger() float64 {
+	if c.Danger <= 0 {
+		return 100
+	}
+	return c.Danger
+}
+
+// checkSaturation sets the saturation of every tier with a capacity,
+// its inbound requests per second as a percentage of the capacity,
+// and shows the tier as warning or danger as it nears or reaches it.
+// Tiers without a capacity have no saturation
+func (v *Vizceral) checkSaturation() {
+	rates := make(map[string]float64)
+	for _, con := range v.ConnectionMap.connections {
+		if v.config.Ships[con.Target].Capacity <= 0 {
+			continue
+		}
+		rates[con.Target] += float64(con.Metrics.Sum()) / observationWeight / con.window().Seconds()
+	}
+	for name, ship := range v.config.Ships {
+		node, ok := v.NodeMap.nodes[name]
+		if !ok || ship.Capacity <= 0 {
+			continue
+		}
+		saturation := 100 * rates[name] / ship.Capacity
+		node.metadata().Saturation = &saturation
+		switch {
+		case saturation >= v.config.Saturation.danger():
+			node.Class = worse(node.Class, classDanger)
+		case saturation >= v.config.Saturation.warning():
+			node.Class = worse(node.Class, classWarning)
+		}
+	}
+}
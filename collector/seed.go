@@ -0,0 +1,167 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+)
+
+const discoveryConsul = "consul"
+
+// ConsulConfig reads the topology from the Consul catalog at Address,
+// defaulting to http://127.0.0.1:8500. Every registered service is a
+// tier and the upstreams of its Connect sidecar proxies are the
+// targets it connects to. Filter is a Consul filter expression
+// selecting the catalog entries of each service, such as
+// ServiceMeta.team == "web", and Token the ACL token sent with each request
+type ConsulConfig struct {
+	Address string `yaml:"address" json:"address"`
+	Filter  string `yaml:"filter" json:"filter"`
+	Token   string `yaml:"token" json:"-"`
+}
+
+func (c ConsulConfig) address() string {
+	if c.Address == "" {
+		return "http://127.0.0.1:8500"
+	}
+	return strings.TrimSuffix(c.Address, "/")
+}
+
+// topologySource lists the tiers of a service discovery backend, each
+// with the targets it connects to as host:port clients
+type topologySource interface {
+	tiers() (map[string][]string, error)
+}
+
+// newTopologySource returns the source of the Discovery backend, nil
+// when the topology is not seeded from one. It sends its requests with
+// client, the one shared by the outbound integrations
+func newTopologySource(c DiscoveryConfig, client *http.Client) (topologySource, error) {
+	switch c.Backend {
+	case "":
+		return nil, nil
+	case discoveryConsul:
+		return consulSource{config: c.Consul, client: client}, nil
+	}
+	return nil, fmt.Errorf("unknown backend %s, expected %s", c.Backend, discoveryConsul)
+}
+
+// seedTopology adds the tiers of the Discovery backend to the ships
+// before the graph is created. A tier that is also configured keeps
+// the configured settings, its discovered clients are only used when
+// it has none of its own
+func (v *Vizceral) seedTopology() error {
+	source, err := newTopologySource(v.config.Discovery, v.outbound.client)
+	if source == nil || err != nil {
+		return err
+	}
+	tiers, err := source.tiers()
+	if err != nil {
+		return err
+	}
+	ships := make(map[string]Ship, len(v.config.Ships)+len(tiers))
+	for name, clients := range tiers {
+		ships[name] = Ship{Clients: clients}
+	}
+	for name, ship := range v.config.Ships {
+		ships[name] = ships[name].merge(ship)
+	}
+	v.config.Ships = ships
+	log.Printf("seeded %d tiers from %s", len(tiers), v.config.Discovery.Backend)
+	return nil
+}
+
+// consulSource reads tiers from the Consul catalog, see ConsulConfig
+type consulSource struct {
+	config ConsulConfig
+	client *http.Client
+}
+
+// consulEntry is the part of a Consul catalog entry describing a
+// service and, for a Connect sidecar proxy, its upstreams
+type consulEntry struct {
+	ServiceKind  string
+	ServiceName  string
+	ServiceProxy struct {
+		DestinationServiceName string
+		Upstreams              []struct {
+			DestinationName string
+			LocalBindPort   int
+		}
+	}
+}
+
+func (s consulSource) tiers() (map[string][]string, error) {
+	var services map[string][]string
+	if err := s.get("/v1/catalog/services", nil, &services); err != nil {
+		return nil, err
+	}
+	tiers := make(map[string][]string)
+	for name := range services {
+		var entries []consulEntry
+		query := url.Values{}
+		if s.config.Filter != "" {
+			query.Set("filter", s.config.Filter)
+		}
+		if err := s.get("/v1/catalog/service/"+url.PathEscape(name), query, &entries); err != nil {
+			return nil, err
+		}
+		for _, entry := range entries {
+			if entry.ServiceKind != "connect-proxy" {
+				if _, ok := tiers[entry.ServiceName]; !ok {
+					tiers[entry.ServiceName] = nil
+				}
+				continue
+			}
+			tier := entry.ServiceProxy.DestinationServiceName
+			for _, upstream := range entry.ServiceProxy.Upstreams {
+				tiers[tier] = append(tiers[tier], upstream.DestinationName+":"+strconv.Itoa(upstream.LocalBindPort))
+			}
+		}
+	}
+	for tier, clients := range tiers {
+		tiers[tier] = dedupe(clients)
+	}
+	return tiers, nil
+}
+
+// get decodes the JSON response of a Consul API path into out
+func (s consulSource) get(path string, query url.Values, out interface{}) error {
+	u := s.config.address() + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	req, err := http.NewRequest(http.MethodGet, u, nil)
+	if err != nil {
+		return err
+	}
+	if s.config.Token != "" {
+		req.Header.Set("X-Consul-Token", s.config.Token)
+	}
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("consul responded %d to %s", resp.StatusCode, path)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+// dedupe returns clients without repeated entries, in their order
+func dedupe(clients []string) []string {
+	seen := make(map[string]bool, len(clients))
+	out := clients[:0]
+	for _, client := range clients {
+		if !seen[client] {
+			seen[client] = true
+			out = append(out, client)
+		}
+	}
+	return out
+}
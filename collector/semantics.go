@@ -0,0 +1,63 @@
+package collector
+
+import (
+	"encoding/json"
+	"math"
+	"time"
+)
+
+// Semantics of the metrics served by /get, see Config.MetricsFormat
+const (
+	metricsRaw  = "raw"
+	metricsRate = "rate"
+)
+
+// withRateMetrics returns a copy of the graph whose connection metrics
+// are requests per second over the window they cover, as are MaxVolume
+// and MaxVolumes, so edges keep their proportions. It is applied after
+// the other display transforms so it converts what they display
+func (v *Vizceral) withRateMetrics() *Vizceral {
+	view := *v
+	window := snapshotInterval
+	if k := v.config.RollingWindows; k > 1 && v.config.DecayHalfLife <= 0 {
+		window *= time.Duration(k)
+	}
+	perSecond := func(volume int) int {
+		return int(math.Ceil(float64(volume) / observationWeight / window.Seconds()))
+	}
+	view.MaxVolume = perSecond(v.MaxVolume)
+	if v.MaxVolumes != nil {
+		view.MaxVolumes = make(map[string]int, len(v.MaxVolumes))
+		for class, volume := range v.MaxVolumes {
+			view.MaxVolumes[class] = perSecond(volume)
+		}
+	}
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, con := range v.ConnectionMap.connections {
+		converted := con.copied()
+		rate := con.Metrics.rate(con.window())
+		converted.perSecond = map[string]float64{
+			classNormal:  rate.Normal,
+			classWarning: rate.Warning,
+			classDanger:  rate.Danger,
+		}
+		for bucket, r := range rate.Custom {
+			converted.perSecond[bucket] = r
+		}
+		view.ConnectionMap.connections[key] = converted
+	}
+	return &view
+}
+
+// MarshalJSON serves the per second metrics of a connection in place
+// of its counts when they are set
+func (con *VizceralConnection) MarshalJSON() ([]byte, error) {
+	type plain VizceralConnection
+	if con.perSecond == nil {
+		return json.Marshal((*plain)(con))
+	}
+	return json.Marshal(struct {
+		*plain
+		Metrics map[string]float64 `json:"metrics"`
+	}{(*plain)(con), con.perSecond})
+}
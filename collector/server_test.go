@@ -0,0 +1,163 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+)
+
+// updatedField matches the timestamps of /get, which change on every
+// read or snapshot
+var updatedField = regexp.MustCompile(`"(updated|serverUpdateTime)":\d+`)
+
+func newTestServer(t *testing.T, config Config) (*Vizceral, *httptest.Server) {
+	t.Helper()
+	v := New(config)
+	mux := http.NewServeMux()
+	v.RegisterHandlers(mux)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return v, server
+}
+
+func post(t *testing.T, url string, want int) {
+	t.Helper()
+	resp, err := http.Post(url, "text/plain", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != want {
+		t.Fatalf("POST %s responded %d, want %d", url, resp.StatusCode, want)
+	}
+}
+
+func TestGetAfterSnapshot(t *testing.T) {
+	v, server := newTestServer(t, Config{
+		Ships: map[string]Ship{
+			"web": {Clients: []string{"10.0.0.1:80", "10.0.0.2:80"}},
+		},
+		Thresholds:       Thresholds{Warning: 0.1, Danger: 0.5},
+		PlaceholderNodes: true,
+	})
+
+	for i := 0; i < 3; i++ {
+		post(t, server.URL+"/log/complete/web:10.0.0.1", http.StatusOK)
+	}
+	post(t, server.URL+"/log/failed/web:10.0.0.1", http.StatusOK)
+	post(t, server.URL+"/log/failed/web:10.0.0.2", http.StatusOK)
+	post(t, server.URL+"/log/complete/web:10.0.0.3", http.StatusNotAcceptable)
+	v.snapshot()
+
+	resp, err := http.Get(server.URL + "/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET /get responded %d", resp.StatusCode)
+	}
+	if ct := resp.Header.Get("Content-Type"); ct != "application/json" {
+		t.Errorf("Content-Type = %q, want application/json", ct)
+	}
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := updatedField.ReplaceAllString(string(body), `"$1":0`)
+	want := `{"name":"Bottle application map","renderer":"region","layout":"ltrTree","maxVolume":125,"updated":0,"stale":false,` +
+		`"serverUpdateTime":0,"updateInterval":60000,"maintenance":false,` +
+		`"nodes":[` +
+		`{"name":"10.0.0.1","renderer":"region","maxVolume":0,"updated":0,"class":"warning","connected":true},` +
+		`{"name":"10.0.0.2","renderer":"region","maxVolume":0,"updated":0,"class":"danger","connected":true},` +
+		`{"name":"web","renderer":"region","maxVolume":0,"updated":0,"class":"danger","connected":true}],` +
+		`"connections":[` +
+		`{"source":"web","target":"10.0.0.1","metrics":{"normal":75,"danger":25,"warning":0},"class":"warning","errorRate":0.25,"warnRate":0},` +
+		`{"source":"web","target":"10.0.0.2","metrics":{"normal":0,"danger":25,"warning":0},"class":"danger","errorRate":1,"warnRate":0}]}` + "\n"
+	if got != want {
+		t.Errorf("GET /get =\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestAnonymizedLabels(t *testing.T) {
+	v, server := newTestServer(t, Config{
+		Ships: map[string]Ship{
+			"web": {Clients: []string{"10.0.0.1:80"}},
+		},
+		LabelTemplate: "{{.Source}} -> {{.Target}}",
+		Anonymize:     true,
+	})
+	post(t, server.URL+"/log/complete/web:10.0.0.1", http.StatusOK)
+	v.snapshot()
+
+	resp, err := http.Get(server.URL + "/get")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var graph struct {
+		Connections []struct {
+			Source, Target, Label string
+		}
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&graph); err != nil {
+		t.Fatal(err)
+	}
+	if len(graph.Connections) != 1 {
+		t.Fatalf("GET /get returned %d connections, want 1", len(graph.Connections))
+	}
+	con := graph.Connections[0]
+	if want := pseudonym("web") + " -> " + pseudonym("10.0.0.1"); con.Label != want {
+		t.Errorf("label = %q, want %q", con.Label, want)
+	}
+}
+
+// getHistory polls /history with query, returning the snapshots and cursors
+func getHistory(t *testing.T, url, query string) (snapshots int, since int64, sequence uint64) {
+	t.Helper()
+	resp, err := http.Get(url + "/history" + query)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	var history struct {
+		Now       int64
+		Sequence  uint64
+		Snapshots []historyEntry
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&history); err != nil {
+		t.Fatal(err)
+	}
+	return len(history.Snapshots), history.Now, history.Sequence
+}
+
+func TestHistorySameSecondSnapshot(t *testing.T) {
+	v, server := newTestServer(t, Config{
+		Ships:    map[string]Ship{"web": {Clients: []string{"10.0.0.1:80"}}},
+		History:  10,
+		Sequence: true,
+	})
+
+	// a poll before any snapshot must not move the cursor past the
+	// snapshot taken in the same second
+	_, since, sequence := getHistory(t, server.URL, "")
+	v.snapshot()
+	if n, _, _ := getHistory(t, server.URL, fmt.Sprintf("?since=%d", since)); n != 1 {
+		t.Errorf("GET /history?since=%d returned %d snapshots, want 1", since, n)
+	}
+	n, _, sequence := getHistory(t, server.URL, fmt.Sprintf("?sequence=%d", sequence))
+	if n != 1 {
+		t.Errorf("GET /history?sequence=0 returned %d snapshots, want 1", n)
+	}
+
+	// a second snapshot within the same second is told apart by sequence
+	v.snapshot()
+	if n, _, _ := getHistory(t, server.URL, fmt.Sprintf("?sequence=%d", sequence)); n != 1 {
+		t.Errorf("GET /history?sequence=%d returned %d snapshots, want 1", sequence, n)
+	}
+}
@@ -0,0 +1,24 @@
+package collector
+
+import "time"
+
+// settle tracks the windows of a connection within Settling of its
+// creation apart from its steady state metrics. Their observations are
+// summed into Setup, and once the connection has settled the rolling
+// and decayed windows start over so that its Metrics only cover the
+// steady state. The caller must hold the connection's mutex
+func (con *VizceralConnection) settle(settling time.Duration, now time.Time) {
+	con.settling = settling > 0 && now.Sub(con.createdAt) < settling
+	if con.settling {
+		if con.Setup == nil {
+			con.Setup = &Metrics{}
+		}
+		con.Setup.Add(con.shadowMetrics)
+		return
+	}
+	if con.Setup != nil && !con.settled {
+		con.settled = true
+		con.windows = nil
+		con.decayed = decayed{}
+	}
+}
@@ -0,0 +1,40 @@
+package collector
+
+import (
+	"log"
+	"time"
+)
+
+// Flush takes a final snapshot so the last partial window reaches
+// the configured outputs, and waits up to timeout for them to be
+// sent. It should be called once the listeners are stopped, and
+// does nothing unless FlushOnShutdown is set
+func (v *Vizceral) Flush(timeout time.Duration) {
+	if !v.config.FlushOnShutdown {
+		return
+	}
+	v.drainQueues()
+	v.snapshot()
+	if !v.outbound.wait(timeout) {
+		log.Printf("gave up waiting for outbound integrations after %s", timeout)
+		return
+	}
+	log.Printf("flushed the final snapshot")
+}
+
+// drainQueues applies the increments still waiting in the queues
+func (v *Vizceral) drainQueues() {
+	if v.normalQueue == nil {
+		return
+	}
+	for {
+		select {
+		case inc := <-v.dangerQueue:
+			v.apply(inc)
+		case inc := <-v.normalQueue:
+			v.apply(inc)
+		default:
+			return
+		}
+	}
+}
@@ -0,0 +1,46 @@
+package collector
+
+import "fmt"
+
+// checkSLOs compares the success rate of the connections of every
+// tier with an SLO against it. A tier falling short of its SLO is
+// burning its error budget, its node is then shown in danger with a
+// notice. Tiers without traffic in the window are left as they are
+func (v *Vizceral) checkSLOs() {
+	totals := make(map[string]Metrics)
+	for _, con := range v.ConnectionMap.connections {
+		for _, name := range []string{con.Source, con.Target} {
+			if v.config.Ships[name].SLO <= 0 {
+				continue
+			}
+			m := totals[name]
+			m.Add(v.classMetrics(con))
+			totals[name] = m
+		}
+	}
+	for name, ship := range v.config.Ships {
+		node, ok := v.NodeMap.nodes[name]
+		if !ok || ship.SLO <= 0 {
+			continue
+		}
+		node.Notices = nil
+		metadata := node.metadata()
+		target := ship.SLO
+		metadata.SLO = &target
+		metadata.Attainment = nil
+		m := totals[name]
+		if m.Sum() == 0 {
+			continue
+		}
+		attainment := 100 * (1 - m.errorRate())
+		metadata.Attainment = &attainment
+		if attainment >= target {
+			continue
+		}
+		node.Class = classDanger
+		node.Notices = []Notice{{
+			Title:    fmt.Sprintf("burning error budget, %.2f%% of requests succeeded against an SLO of %g%%", attainment, target),
+			Severity: noticeDanger,
+		}}
+	}
+}
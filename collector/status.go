@@ -0,0 +1,47 @@
+package collector
+
+import (
+	"strconv"
+	"strings"
+)
+
+// StatusConfig classifies HTTP response codes into buckets for the
+// ingestion formats that report them, see EnvoyConfig. Warning and
+// Danger list codes such as 503 or classes such as 5xx, an exact code
+// taking precedence over a class. Codes in neither are normal. Danger
+// defaults to 5xx and 0, which Envoy logs when no response was sent
+type StatusConfig struct {
+	Warning []string `yaml:"warning" json:"warning"`
+	Danger  []string `yaml:"danger" json:"danger"`
+}
+
+func (c StatusConfig) danger() []string {
+	if len(c.Danger) == 0 {
+		return []string{"5xx", "0"}
+	}
+	return c.Danger
+}
+
+// bucketOf returns the bucket a response code is counted in
+func (c StatusConfig) bucketOf(code int) string {
+	exact := strconv.Itoa(code)
+	class := exact[:1] + "xx"
+	for _, pattern := range []string{exact, class} {
+		if matchesStatus(c.danger(), pattern) {
+			return classDanger
+		}
+		if matchesStatus(c.Warning, pattern) {
+			return classWarning
+		}
+	}
+	return classNormal
+}
+
+func matchesStatus(patterns []string, code string) bool {
+	for _, pattern := range patterns {
+		if strings.EqualFold(pattern, code) {
+			return true
+		}
+	}
+	return false
+}
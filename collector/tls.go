@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io/ioutil"
+)
+
+// TLSConfig enables TLS on the HTTP listeners when CertFile and
+// KeyFile are set. ClientCAFile additionally requires every client
+// to present a certificate signed by one of the CAs in the bundle
+type TLSConfig struct {
+	CertFile     string `yaml:"certFile" json:"certFile"`
+	KeyFile      string `yaml:"keyFile" json:"keyFile"`
+	ClientCAFile string `yaml:"clientCAFile" json:"clientCAFile"`
+}
+
+// ServerConfig builds the server side tls.Config, it returns nil
+// when TLS is not configured
+func (c TLSConfig) ServerConfig() (*tls.Config, error) {
+	if c.CertFile == "" && c.KeyFile == "" {
+		if c.ClientCAFile != "" {
+			return nil, fmt.Errorf("clientCAFile requires certFile and keyFile")
+		}
+		return nil, nil
+	}
+	cert, err := tls.LoadX509KeyPair(c.CertFile, c.KeyFile)
+	if err != nil {
+		return nil, fmt.Errorf("loading server certificate: %v", err)
+	}
+	conf := &tls.Config{Certificates: []tls.Certificate{cert}}
+	if c.ClientCAFile != "" {
+		pem, err := ioutil.ReadFile(c.ClientCAFile)
+		if err != nil {
+			return nil, fmt.Errorf("loading client CA bundle: %v", err)
+		}
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, fmt.Errorf("no certificates found in %s", c.ClientCAFile)
+		}
+		conf.ClientCAs = pool
+		conf.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+	return conf, nil
+}
@@ -0,0 +1,64 @@
+package collector
+
+import (
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"sync/atomic"
+)
+
+// maxDatagram is the largest UDP payload read at once
+const maxDatagram = 65535
+
+// ServeUDP logs the lines of the datagrams read from conn until it is
+// closed. Each line is a connection key, a bucket and an optional
+// count of requests defaulting to 1, such as "web:db normal 5" or
+// "web:db danger". Delivery is best effort, malformed lines are
+// counted and dropped without a reply and unknown connections are
+// handled as by the HTTP endpoints
+func (v *Vizceral) ServeUDP(conn net.PacketConn) error {
+	buf := make([]byte, maxDatagram)
+	for {
+		n, addr, err := conn.ReadFrom(buf)
+		if err != nil {
+			if errors.Is(err, net.ErrClosed) {
+				return nil
+			}
+			return err
+		}
+		if v.isDraining() {
+			continue
+		}
+		client := ""
+		if host, _, err := net.SplitHostPort(addr.String()); err == nil {
+			client = host
+		}
+		for _, line := range strings.Split(string(buf[:n]), "\n") {
+			if line = strings.TrimSpace(line); line != "" {
+				v.logUDPLine(line, client)
+			}
+		}
+	}
+}
+
+// logUDPLine logs one line of a datagram, see ServeUDP
+func (v *Vizceral) logUDPLine(line, client string) {
+	fields := strings.Fields(line)
+	count := 1
+	if len(fields) == 3 {
+		n, err := strconv.Atoi(fields[2])
+		if err != nil || n < 1 {
+			atomic.AddUint64(&v.udpMalformed, 1)
+			return
+		}
+		count = n
+	}
+	if len(fields) < 2 || len(fields) > 3 || len(fields[0]) > v.config.maxKeyLength() || !v.config.hasBucket(fields[1]) {
+		atomic.AddUint64(&v.udpMalformed, 1)
+		return
+	}
+	var m Metrics
+	m.addBucket(fields[1], count*observationWeight)
+	v.logConnection(fields[0], increment{metrics: m, client: client})
+}
@@ -0,0 +1,341 @@
+package collector
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+)
+
+// Names of the synthetic nodes that hold the connections
+// left out of a top N view
+const (
+	otherSources = "other sources"
+	otherTargets = "other targets"
+)
+
+// output returns the graph as it should be served, applying the
+// configured display transforms
+func (v *Vizceral) output() *Vizceral {
+	view := v
+	if v.config.MinEdgeVolume > 0 {
+		view = view.withoutLowVolume()
+	}
+	if v.config.HideIdleNodes {
+		view = view.withoutIdleNodes()
+	}
+	view = view.renamed()
+	if v.config.Anonymize {
+		view = view.anonymized()
+	}
+	if len(v.config.Volume.Weights) > 0 {
+		view = view.withVolumeWeights()
+	}
+	if v.config.Volume.Cap > 0 {
+		view = view.withVolumeCap()
+	}
+	if v.config.DangerFloor.ErrorRate > 0 {
+		view = view.withDangerFloor()
+	}
+	if v.config.MetricsFormat == metricsRate {
+		view = view.withRateMetrics()
+	}
+	return view
+}
+
+// renamed returns the graph under the names it is served with before
+// anonymization, aggregated and resolved as configured
+func (v *Vizceral) renamed() *Vizceral {
+	view := v
+	if v.config.Aggregate.enabled() {
+		view = view.aggregated()
+	}
+	if v.resolver != nil {
+		view = view.withHostnames()
+	}
+	return view
+}
+
+// withVolumeWeights returns a copy of the graph where the metrics of
+// connections are weighted by bucket, see VolumeConfig.Weights
+func (v *Vizceral) withVolumeWeights() *Vizceral {
+	view := *v
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, con := range v.ConnectionMap.connections {
+		weighted := con.copied()
+		weighted.Metrics = v.config.Volume.weighted(con.Metrics)
+		view.ConnectionMap.connections[key] = weighted
+	}
+	return &view
+}
+
+// withVolumeCap returns a copy of the graph where the metrics of
+// connections above the volume cap are scaled down to it
+func (v *Vizceral) withVolumeCap() *Vizceral {
+	limit := v.config.Volume.Cap
+	view := *v
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, con := range v.ConnectionMap.connections {
+		view.ConnectionMap.connections[key] = con
+		volume := con.Metrics.Sum()
+		if volume <= limit {
+			continue
+		}
+		capped := con.copied()
+		capped.Metrics = con.Metrics.scaled(limit, volume)
+		metadata := ConnectionMetadata{}
+		if con.Metadata != nil {
+			metadata = *con.Metadata
+		}
+		metadata.Volume = &volume
+		capped.Metadata = &metadata
+		capped.Notices = append(append([]Notice(nil), con.Notices...), Notice{
+			Title: fmt.Sprintf("volume capped at %d from %d", limit, volume),
+		})
+		view.ConnectionMap.connections[key] = capped
+	}
+	return &view
+}
+
+// withShadowMetrics returns a copy of the graph showing the metrics
+// of the window in progress, used before the first snapshot. They are
+// not classified as the window is still incomplete
+func (v *Vizceral) withShadowMetrics() *Vizceral {
+	view := *v
+	view.MaxVolume = 0
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, con := range v.ConnectionMap.connections {
+		preview := con.copied()
+		preview.Metrics = Metrics{}
+		con.mu.Lock()
+		preview.Metrics.Add(con.shadowMetrics)
+		con.mu.Unlock()
+		preview.ErrorRate = preview.Metrics.errorRate()
+		preview.WarnRate = preview.Metrics.warnRate()
+		if volume := preview.Metrics.Sum(); volume > view.MaxVolume {
+			view.MaxVolume = volume
+		}
+		view.ConnectionMap.connections[key] = preview
+	}
+	return &view
+}
+
+// withDangerFloor returns a copy of the graph where connections at or
+// above the danger floor are displayed in danger with a boosted volume
+func (v *Vizceral) withDangerFloor() *Vizceral {
+	floor := v.config.DangerFloor
+	view := *v
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, con := range v.ConnectionMap.connections {
+		view.ConnectionMap.connections[key] = con
+		if con.forceNormal || con.Metrics.Sum() == 0 || v.classMetrics(con).errorRate() < floor.ErrorRate {
+			continue
+		}
+		boosted := con.copied()
+		if volume := floor.volume(v.MaxVolume); boosted.Metrics.Danger < volume {
+			boosted.Metrics.Danger = volume
+		}
+		boosted.Class = classDanger
+		view.ConnectionMap.connections[key] = boosted
+	}
+	return &view
+}
+
+// pseudonym returns the stable name a node is shown under when
+// the output is anonymized
+func pseudonym(name string) string {
+	sum := sha256.Sum256([]byte(name))
+	return "node-" + hex.EncodeToString(sum[:4])
+}
+
+// anonymized returns a copy of the graph where node names and
+// connection endpoints are replaced by their pseudonyms
+func (v *Vizceral) anonymized() *Vizceral {
+	view := *v
+	if v.EntryNode != "" {
+		view.EntryNode = pseudonym(v.EntryNode)
+	}
+	view.NodeMap = &VizceralNodes{nodes: make(map[string]*VizceralNode)}
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, node := range v.NodeMap.nodes {
+		renamed := *node
+		renamed.Name = pseudonym(node.Name)
+		if node.Nodes != nil {
+			renamed.Nodes, renamed.Connections = anonymizedServices(node)
+		}
+		view.NodeMap.nodes[key] = &renamed
+	}
+	for key, con := range v.ConnectionMap.connections {
+		renamed := con.copied()
+		renamed.Source = pseudonym(con.Source)
+		renamed.Target = pseudonym(con.Target)
+		v.label(renamed)
+		view.ConnectionMap.connections[key] = renamed
+	}
+	return &view
+}
+
+// anonymizedServices returns copies of the services of a tier and
+// their connections under pseudonyms
+func anonymizedServices(node *VizceralNode) (*VizceralNodes, *VizceralConnections) {
+	nodes := &VizceralNodes{nodes: make(map[string]*VizceralNode)}
+	cons := &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, child := range node.Nodes.nodes {
+		renamed := *child
+		renamed.Name = pseudonym(child.Name)
+		nodes.nodes[key] = &renamed
+	}
+	for key, con := range node.Connections.connections {
+		renamed := con.copied()
+		renamed.Source = pseudonym(con.Source)
+		renamed.Target = pseudonym(con.Target)
+		cons.connections[key] = renamed
+	}
+	return nodes, cons
+}
+
+// anonymizeMapping returns the pseudonym of every node along with the
+// name it replaces, so anonymized output can be decoded. The names are
+// those /get serves, aggregated and resolved
+func (v *Vizceral) anonymizeMapping(w http.ResponseWriter, r *http.Request) {
+	v.graphMu.RLock()
+	view := v.renamed()
+	mapping := make(map[string]string, len(view.NodeMap.nodes))
+	for _, node := range view.NodeMap.nodes {
+		mapping[pseudonym(node.Name)] = node.Name
+		if node.Nodes != nil {
+			for _, child := range node.Nodes.nodes {
+				mapping[pseudonym(child.Name)] = child.Name
+			}
+		}
+	}
+	v.graphMu.RUnlock()
+	w.Header().Set("Content-Type", "application/json")
+	err := json.NewEncoder(w).Encode(mapping)
+	if err != nil {
+		w.WriteHeader(http.StatusInternalServerError)
+		w.Write([]byte("500 - failed to convert mapping into JSON"))
+		return
+	}
+}
+
+// forCluster returns a copy of the graph where the metrics of every
+// connection are those logged by the given cluster. Classes are still
+// those computed from the metrics of all clusters
+func (v *Vizceral) forCluster(cluster string) *Vizceral {
+	view := *v
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, con := range v.ConnectionMap.connections {
+		filtered := con.copied()
+		filtered.Metrics = con.clusters[cluster]
+		filtered.ErrorRate = filtered.Metrics.errorRate()
+		filtered.WarnRate = filtered.Metrics.warnRate()
+		filtered.Dimensions = nil
+		view.ConnectionMap.connections[key] = filtered
+	}
+	return &view
+}
+
+// withoutIdleNodes returns a copy of the graph without the nodes that
+// have no connection carrying traffic in the current window, other
+// than the entry node. Their empty connections are left out as well
+func (v *Vizceral) withoutIdleNodes() *Vizceral {
+	active := map[string]bool{v.EntryNode: true}
+	for _, origin := range v.origins {
+		active[origin.name] = true
+	}
+	for _, con := range v.ConnectionMap.connections {
+		if con.Metrics.Sum() > 0 {
+			active[con.Source] = true
+			active[con.Target] = true
+		}
+	}
+	view := *v
+	view.NodeMap = &VizceralNodes{nodes: make(map[string]*VizceralNode)}
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, node := range v.NodeMap.nodes {
+		if active[node.Name] {
+			view.NodeMap.nodes[key] = node
+		}
+	}
+	for key, con := range v.ConnectionMap.connections {
+		if active[con.Source] && active[con.Target] {
+			view.ConnectionMap.connections[key] = con
+		}
+	}
+	return &view
+}
+
+// withoutLowVolume returns a copy of the graph without the connections
+// below MinEdgeVolume, their nodes are kept
+func (v *Vizceral) withoutLowVolume() *Vizceral {
+	view := *v
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, con := range v.ConnectionMap.connections {
+		if con.Metrics.observations() >= v.config.MinEdgeVolume {
+			view.ConnectionMap.connections[key] = con
+		}
+	}
+	return &view
+}
+
+// withHostnames returns a copy of the graph where IP node names and
+// connection endpoints are replaced by their resolved hostnames
+func (v *Vizceral) withHostnames() *Vizceral {
+	view := *v
+	view.NodeMap = &VizceralNodes{nodes: make(map[string]*VizceralNode)}
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for key, node := range v.NodeMap.nodes {
+		renamed := *node
+		renamed.Name = v.resolver.name(node.Name)
+		view.NodeMap.nodes[key] = &renamed
+	}
+	for key, con := range v.ConnectionMap.connections {
+		renamed := con.copied()
+		renamed.Source = v.resolver.name(con.Source)
+		renamed.Target = v.resolver.name(con.Target)
+		v.label(renamed)
+		view.ConnectionMap.connections[key] = renamed
+	}
+	return &view
+}
+
+// top returns a copy of the graph with only the n highest volume
+// connections and the nodes they touch. The remaining connections
+// are summed into a single edge between two synthetic nodes
+func (v *Vizceral) top(n int) *Vizceral {
+	cons := make([]*VizceralConnection, 0, len(v.ConnectionMap.connections))
+	for _, con := range v.ConnectionMap.connections {
+		cons = append(cons, con)
+	}
+	if n >= len(cons) {
+		return v
+	}
+	sort.Slice(cons, func(i, j int) bool {
+		return cons[i].Metrics.Sum() > cons[j].Metrics.Sum()
+	})
+
+	view := *v
+	view.NodeMap = &VizceralNodes{nodes: make(map[string]*VizceralNode)}
+	view.ConnectionMap = &VizceralConnections{connections: make(map[string]*VizceralConnection)}
+	for _, con := range cons[:n] {
+		view.ConnectionMap.connections[v.key(con.Source, con.Target)] = con
+		for _, name := range []string{con.Source, con.Target} {
+			if node, ok := v.NodeMap.nodes[name]; ok {
+				view.NodeMap.nodes[name] = node
+			}
+		}
+	}
+
+	other := &VizceralConnection{Source: otherSources, Target: otherTargets}
+	for _, con := range cons[n:] {
+		other.Metrics.Add(con.Metrics)
+	}
+	view.ConnectionMap.connections[v.key(otherSources, otherTargets)] = other
+	for _, name := range []string{otherSources, otherTargets} {
+		view.NodeMap.nodes[name] = &VizceralNode{Name: name, Renderer: "region", Updated: v.Updated, UpdatedAt: v.UpdatedAt}
+	}
+	return &view
+}
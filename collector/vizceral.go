@@ -0,0 +1,1180 @@
+package collector
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"text/template"
+	"time"
+
+	"github.com/expr-lang/expr/vm"
+)
+
+// VizceralNode holds the metadata for a given app tier
+type VizceralNode struct {
+	Name      string        `json:"name"`
+	Renderer  string        `json:"renderer"`
+	MaxVolume int           `json:"maxVolume"`
+	Updated   int64         `json:"updated"`
+	UpdatedAt string        `json:"updatedAt,omitempty"`
+	Metadata  *NodeMetadata `json:"metadata,omitempty"`
+	Class     string        `json:"class,omitempty"`
+	Connected bool          `json:"connected"`
+	Notices   []Notice      `json:"notices,omitempty"`
+
+	// Nodes and Connections are the services of a tier with
+	// Ship.Services, shown when the tier is focused
+	Nodes       *VizceralNodes       `json:"nodes,omitempty"`
+	Connections *VizceralConnections `json:"connections,omitempty"`
+}
+
+// NodeMetadata holds the optional extra details of a node
+// VolumeIn and VolumeOut are the volumes of the connections
+// into and out of the node in the last snapshot
+// With NormalizeReplicas they are per replica and VolumeInTotal and
+// VolumeOutTotal hold the volumes of the whole tier
+// Group is the layer the node is laid out in and Type the kind of
+// node it is, see Ship
+// SLO is the success percentage the tier targets and Attainment the
+// percentage of successful requests in the last snapshot
+// Saturation is the inbound rate of the tier as a percentage of its
+// capacity, see Ship
+type NodeMetadata struct {
+	VolumeIn       *int     `json:"volumeIn,omitempty"`
+	VolumeOut      *int     `json:"volumeOut,omitempty"`
+	VolumeInTotal  *int     `json:"volumeInTotal,omitempty"`
+	VolumeOutTotal *int     `json:"volumeOutTotal,omitempty"`
+	Group          string   `json:"group,omitempty"`
+	Type           string   `json:"type,omitempty"`
+	SLO            *float64 `json:"slo,omitempty"`
+	Attainment     *float64 `json:"attainment,omitempty"`
+	Saturation     *float64 `json:"saturation,omitempty"`
+}
+
+// metadata returns the node's metadata, creating it when missing
+func (node *VizceralNode) metadata() *NodeMetadata {
+	if node.Metadata == nil {
+		node.Metadata = &NodeMetadata{}
+	}
+	return node.Metadata
+}
+
+// Names of the classes a connection can be in
+const (
+	classNormal  = "normal"
+	classWarning = "warning"
+	classDanger  = "danger"
+)
+
+// VizceralConnection holds the stats for a given src:dst pair
+// shadowMetrics holds the current minutes accumulating stats
+// Metrics holds the previous minutes complete stats
+// Class is the displayed class, class is the computed one
+type VizceralConnection struct {
+	Source       string              `json:"source"`
+	Target       string              `json:"target"`
+	Metrics      Metrics             `json:"metrics"`
+	Class        string              `json:"class,omitempty"`
+	Latency      *Latency            `json:"latency,omitempty"`
+	ErrorRate    float64             `json:"errorRate"`
+	WarnRate     float64             `json:"warnRate"`
+	Notices      []Notice            `json:"notices,omitempty"`
+	Metadata     *ConnectionMetadata `json:"metadata,omitempty"`
+	Dimensions   Dimensions          `json:"dimensions,omitempty"`
+	Rate         *Rate               `json:"rate,omitempty"`
+	Bytes        *Bytes              `json:"bytes,omitempty"`
+	Label        string              `json:"label,omitempty"`
+	Availability *float64            `json:"availability,omitempty"`
+	LatencySLO   *LatencySLO         `json:"latencySlo,omitempty"`
+	Setup        *Metrics            `json:"setup,omitempty"`
+	mu           *lockSlot
+
+	// ingested is the state written while logging, guarded by mu. It
+	// is held by pointer so that the copies made by views do not read
+	// it, see copied
+	*ingested
+
+	// clusters splits the metrics by the cluster that logged them,
+	// when Clusters is enabled
+	clusters Dimensions
+
+	class          string
+	suppressAlerts bool
+	forceNormal    bool
+
+	// windows holds the most recent windows in rolling mode
+	windows []Metrics
+
+	// availabilityWindows holds the counts of the windows the
+	// availability is computed over
+	availabilityWindows []availabilityWindow
+
+	// decayed is the moving average of the windows in decay mode
+	decayed decayed
+
+	// createdAt is when the connection was created, settling is set
+	// while it is within Config.Settling of it and settled once it
+	// has left it, see settle
+	createdAt time.Time
+	settling  bool
+	settled   bool
+
+	// held is set when the previous metrics were kept over an empty window
+	held bool
+
+	// baseline is the learned error rate in adaptive mode
+	baseline baseline
+
+	// dangerStreak counts the consecutive snapshots spent in danger
+	dangerStreak int
+
+	// paged is the severity of the open PagerDuty incident, if any, and
+	// pageStreak the consecutive snapshots spent in a paging class
+	paged      string
+	pageStreak int
+
+	// created is set for connections created by UnknownConnections and
+	// lastSeen is the unix time of the last window with traffic, used
+	// to evict them beyond MaxConnections
+	created  bool
+	lastSeen int64
+
+	// changed is the unix time of the last snapshot that changed the
+	// metrics or class of the connection, and changedSequence the
+	// sequence number of that snapshot
+	changed         int64
+	changedSequence uint64
+
+	// notice is the automatic notice kept shown by NoticeHold
+	notice heldNotice
+
+	// deadman overrides Config.Deadman for the connection and silentFor
+	// is how long it has gone without data beyond its deadman interval
+	deadman   time.Duration
+	silentFor time.Duration
+
+	// expectedRate is the usual requests per second of the connection
+	expectedRate float64
+
+	// latencySLO is the latency in milliseconds the requests of the
+	// connection should stay within, 0 when it has none
+	latencySLO float64
+
+	// sampleRate is the fraction of its requests that are logged, 0
+	// when all of them are
+	sampleRate float64
+
+	// parent is the tier connection of a service connection, which
+	// is also given everything logged against it
+	parent *VizceralConnection
+
+	// annotations are the metadata set through /connection/metadata
+	annotations map[string]interface{}
+
+	// perSecond replaces Metrics in the JSON of a view in rate format
+	perSecond map[string]float64
+}
+
+// ingested is the part of a connection written by the log requests
+type ingested struct {
+	shadowMetrics    Metrics
+	shadowDimensions Dimensions
+	shadowLatency    latencySamples
+
+	// shadowClusters splits the metrics of the window by cluster
+	shadowClusters Dimensions
+
+	// shadowFailures counts the failed requests of the window by type
+	shadowFailures map[string]int
+
+	// shadowBytes counts the bytes transferred in the window, nil
+	// until a request reports them
+	shadowBytes *Bytes
+
+	// shadowLatencySLO splits the requests of the window by latencySLO
+	shadowLatencySLO LatencySLO
+
+	// histogram holds the latencies reported since the connection was
+	// created, nil unless LatencyHistogram gave it one
+	histogram *latencyHistogram
+}
+
+// copied returns a copy of the connection for a view to change,
+// without the state written while logging
+func (con *VizceralConnection) copied() *VizceralConnection {
+	c := *con
+	c.ingested = nil
+	return &c
+}
+
+// ConnectionMetadata holds the optional extra details of a connection
+// Volume is the true volume of a connection displayed with a capped one
+// FailureBreakdown counts the failed requests logged with a type, they
+// are also counted in the danger metrics
+// Annotations are set at runtime through /connection/metadata
+type ConnectionMetadata struct {
+	Volume           *int                   `json:"volume,omitempty"`
+	FailureBreakdown map[string]int         `json:"failureBreakdown,omitempty"`
+	Annotations      map[string]interface{} `json:"annotations,omitempty"`
+}
+
+// Notice is an annotation shown by Vizceral on a node or connection
+// Severity is 0 for info, 1 for warning and 2 for danger
+type Notice struct {
+	Title    string `json:"title"`
+	Link     string `json:"link,omitempty"`
+	Severity int    `json:"severity,omitempty"`
+}
+
+// Severities of the warning and danger notices
+const (
+	noticeWarning = 1
+	noticeDanger  = 2
+)
+
+// rotate commits the in progress window into Metrics, the caller
+// must hold the connection's mutex
+func (con *VizceralConnection) rotate(c Config) {
+	var metrics Metrics
+	if c.DecayHalfLife > 0 {
+		metrics = con.decayed.add(con.shadowMetrics, decayFactor(c.DecayHalfLife))
+	} else {
+		metrics = con.roll(con.shadowMetrics, c.RollingWindows)
+	}
+	if c.Availability.Windows > 0 {
+		con.Availability = con.availability(con.shadowMetrics, c.Availability)
+	}
+	latency := con.shadowLatency.latency()
+	dims, clusters, failures, bytes := con.shadowDimensions, con.shadowClusters, con.shadowFailures, con.shadowBytes
+	con.shadowMetrics = Metrics{}
+	con.shadowLatency = latencySamples{}
+	con.shadowDimensions = nil
+	con.shadowClusters = nil
+	con.shadowFailures = nil
+	con.shadowBytes = nil
+	latencySLO := con.shadowLatencySLO
+	con.shadowLatencySLO = LatencySLO{}
+
+	// An active connection that saw no traffic keeps its previous
+	// metrics for one window so it doesn't flicker out of view
+	if c.HoldEmpty && metrics.Sum() == 0 && con.Metrics.Sum() > 0 && !con.held {
+		con.held = true
+		return
+	}
+	con.held = false
+	con.Metrics = metrics
+	con.Latency = latency
+	con.Dimensions = dims
+	con.clusters = clusters
+	con.Bytes = nil
+	if bytes != nil {
+		con.Bytes = bytes.throughput()
+	}
+	con.LatencySLO = nil
+	if con.latencySLO > 0 && latencySLO.Within+latencySLO.Over > 0 {
+		latencySLO.Attainment = float64(latencySLO.Within) / float64(latencySLO.Within+latencySLO.Over)
+		con.LatencySLO = &latencySLO
+	}
+	con.Metadata = nil
+	if len(failures) > 0 || con.annotations != nil {
+		con.Metadata = &ConnectionMetadata{FailureBreakdown: failures, Annotations: con.annotations}
+	}
+}
+
+// window returns how long the committed Metrics cover, a single
+// snapshot interval unless rolling windows are summed
+func (con *VizceralConnection) window() time.Duration {
+	if len(con.windows) > 1 {
+		return time.Duration(len(con.windows)) * snapshotInterval
+	}
+	return snapshotInterval
+}
+
+// roll records a completed window and returns the sum of the last
+// k windows, with k <= 1 only the latest window is kept
+func (con *VizceralConnection) roll(window Metrics, k int) Metrics {
+	if k <= 1 {
+		con.windows = nil
+		return window
+	}
+	con.windows = append(con.windows, window)
+	if len(con.windows) > k {
+		con.windows = con.windows[len(con.windows)-k:]
+	}
+	var sum Metrics
+	for _, m := range con.windows {
+		sum.Add(m)
+	}
+	return sum
+}
+
+// VizceralNodes holds a map of VizceralNode
+// Each node key should be an app tier
+// VizceralNodes implements the MashalJSON interface to
+// convert the connections into a flat list
+type VizceralNodes struct {
+	nodes map[string]*VizceralNode
+}
+
+// VizceralConnections holds a map of VizceralMetrics
+// Each connection key should be a src:dst pair
+// VizceralConnections implements the MashalJSON interface to
+// convert the connections into a flat list
+type VizceralConnections struct {
+	connections map[string]*VizceralConnection
+}
+
+// Vizceral is a data structure that holds the traffic graph
+type Vizceral struct {
+	config     Config
+	Name       string         `json:"name"`
+	Renderer   string         `json:"renderer"`
+	Layout     string         `json:"layout"`
+	EntryNode  string         `json:"entryNode,omitempty"`
+	MaxVolume  int            `json:"maxVolume"`
+	MaxVolumes map[string]int `json:"maxVolumes,omitempty"`
+	Updated    int64          `json:"updated"`
+	UpdatedAt  string         `json:"updatedAt,omitempty"`
+	Stale      bool           `json:"stale"`
+
+	// ServerUpdateTime is the unix time of the last snapshot in
+	// milliseconds and UpdateInterval the milliseconds between
+	// snapshots, for dashboards computing staleness themselves
+	ServerUpdateTime int64 `json:"serverUpdateTime"`
+	UpdateInterval   int64 `json:"updateInterval"`
+
+	// Sequence counts the snapshots that rotated metrics, served when
+	// Config.Sequence is set
+	Sequence uint64 `json:"sequence,omitempty"`
+
+	Maintenance   bool                 `json:"maintenance"`
+	NodeMap       *VizceralNodes       `json:"nodes"`
+	ConnectionMap *VizceralConnections `json:"connections"`
+
+	// normalQueue and dangerQueue buffer increments when
+	// buffered ingestion is enabled. Danger gets its own queue
+	// so failures are not dropped behind a flood of successes
+	normalQueue chan increment
+	dangerQueue chan increment
+
+	// counters are updated atomically, they are held by pointer so
+	// that the copies of the graph made by views do not read them
+	*counters
+
+	// children are the connections of tier services keyed by
+	// tier/service:target, see Ship.Services
+	children map[string]*VizceralConnection
+
+	// graphMu guards the node and connection maps, which may
+	// grow at runtime when unknown connections are created, and
+	// is held exclusively while a snapshot is taken
+	graphMu *sync.RWMutex
+
+	// postProcess are the hooks run after every snapshot
+	postProcess []PostProcess
+
+	// sequence counts the snapshots that rotated metrics, the caller
+	// must hold graphMu
+	sequence uint64
+
+	// histogramBuckets are the bounds of the latency histograms, see
+	// LatencyHistogram
+	histogramBuckets []float64
+
+	// started is when the collector was created, for the alert warmup
+	started time.Time
+
+	// webhookFilter leaves near constant connections out of the volumes
+	// posted to the webhook
+	webhookFilter *emitFilter
+
+	// grouped is set when tiers are grouped into layers
+	grouped bool
+
+	// history holds past snapshots, nil when disabled
+	history *history
+
+	// resolver looks up hostnames of targets, nil when disabled
+	resolver *resolver
+
+	// proxies are trusted to report the client address
+	proxies trustedProxies
+
+	// origins are the compiled Entry.Origins
+	origins []compiledOrigin
+
+	// targetRules are the compiled Aggregate.TargetRules
+	targetRules []compiledRule
+
+	// classification is the compiled Classification, nil when unset
+	classification *vm.Program
+
+	// colorBy is the compiled ColorBy expression, nil unless its
+	// metric is expression
+	colorBy *vm.Program
+
+	// labelTemplate is the parsed LabelTemplate, nil when unset
+	labelTemplate *template.Template
+
+	// location is the timezone of UpdatedAt, nil when it is disabled
+	location *time.Location
+
+	// outbound is shared by all outbound integrations
+	outbound *outboundClient
+
+	// locks are the shards guarding connection metrics
+	locks []*shard
+}
+
+// counters are the state of a collector updated atomically while the
+// graph is read
+type counters struct {
+	// droppedNormal and droppedDanger count the increments dropped
+	// from full queues
+	droppedNormal uint64
+	droppedDanger uint64
+
+	// timedOutNormal and timedOutDanger count the increments given up
+	// on after Buffer.MaxWait with the block policy
+	timedOutNormal uint64
+	timedOutDanger uint64
+
+	// counters of log requests for unknown connections
+	unknownRejected uint64
+	unknownCreated  uint64
+	unknownIgnored  uint64
+
+	// unknownForbidden counts the keys refused by StrictConnections
+	unknownForbidden uint64
+
+	// udpMalformed counts the lines dropped by ServeUDP
+	udpMalformed uint64
+
+	// suppressedNotices counts the notices dropped beyond MaxNotices
+	suppressedNotices uint64
+
+	// evicted counts the created connections evicted beyond MaxConnections
+	evicted uint64
+
+	// discovered counts the connections created by Discovery
+	discovered uint64
+
+	// lastSnapshot is the unix nano time of the last snapshot
+	lastSnapshot int64
+
+	// rotated is 1 once a snapshot has committed metrics
+	rotated int32
+
+	// connectionCount and nodeCount are the sizes of the connection
+	// and node maps at the last snapshot
+	connectionCount int64
+	nodeCount       int64
+
+	// inFlight is the number of log requests being handled
+	inFlight int64
+
+	// maintenance is 1 while snapshots are frozen, during which
+	// the observations of each window are discarded
+	maintenance int32
+
+	// draining is 1 while log requests are refused ahead of shutdown
+	draining int32
+
+	// histograms counts the connections with a latency histogram
+	histograms int64
+
+	// dedicatedLocks counts the connections HotLocks gave their own
+	dedicatedLocks int64
+}
+
+// New returns a new Vizceral object built from the given config
+// and starts taking snapshots in the background
+func New(config Config) *Vizceral {
+	v := new(Vizceral)
+	v.counters = new(counters)
+	v.config = config
+	v.Name = "Bottle application map"
+	v.Renderer = "region"
+	v.Layout = "ltrTree"
+	v.MaxVolume = 0
+	v.NodeMap = new(VizceralNodes)
+	v.NodeMap.nodes = make(map[string]*VizceralNode)
+	v.ConnectionMap = new(VizceralConnections)
+	v.ConnectionMap.connections = make(map[string]*VizceralConnection)
+
+	v.started = time.Now()
+	v.lastSnapshot = v.started.UnixNano()
+	v.ServerUpdateTime = v.started.UnixNano() / int64(time.Millisecond)
+	v.UpdateInterval = int64(snapshotInterval / time.Millisecond)
+	v.outbound = newOutboundClient(v.config.Outbound)
+	v.webhookFilter = newEmitFilter(v.config.Webhook.MinChange)
+	if ts := v.config.Timestamps; ts.RFC3339 {
+		location, err := time.LoadLocation(ts.Timezone)
+		if err != nil {
+			log.Fatalf("invalid timezone %s: %v", ts.Timezone, err)
+		}
+		v.location = location
+	}
+	proxies, err := parseTrustedProxies(v.config.TrustedProxies)
+	if err != nil {
+		log.Fatalf("invalid trustedProxies: %v", err)
+	}
+	v.proxies = proxies
+	if v.config.Entry.Enabled {
+		origins, err := compileOrigins(v.config.Entry.Origins)
+		if err != nil {
+			log.Fatalf("invalid entry.origins: %v", err)
+		}
+		v.origins = origins
+	}
+	switch v.config.MetricsFormat {
+	case "", metricsRaw, metricsRate:
+	default:
+		log.Fatalf("invalid metricsFormat %s, expected raw or rate", v.config.MetricsFormat)
+	}
+	switch v.config.Readiness {
+	case "", readyListening, readyConfig, readySnapshot:
+	default:
+		log.Fatalf("invalid readiness %s, expected listening, config or snapshot", v.config.Readiness)
+	}
+	switch v.config.Availability.Mode {
+	case "", availabilityWindows, availabilityRequests:
+	default:
+		log.Fatalf("invalid availability.mode %s, expected windows or requests", v.config.Availability.Mode)
+	}
+	rules, err := compileRules(v.config.Aggregate.TargetRules)
+	if err != nil {
+		log.Fatalf("invalid aggregate.targetRules: %v", err)
+	}
+	v.targetRules = rules
+	program, err := compileClassification(v.config.Classification)
+	if err != nil {
+		log.Fatalf("invalid classification: %v", err)
+	}
+	v.classification = program
+	if v.config.ColorBy.Metric != "" && v.config.Classification != "" {
+		log.Fatalf("colorBy cannot be combined with classification")
+	}
+	colorBy, err := compileColorBy(v.config.ColorBy)
+	if err != nil {
+		log.Fatalf("invalid colorBy: %v", err)
+	}
+	v.colorBy = colorBy
+	v.histogramBuckets = v.config.LatencyHistogram.buckets()
+	label, err := compileLabel(v.config.LabelTemplate)
+	if err != nil {
+		log.Fatalf("invalid labelTemplate: %v", err)
+	}
+	v.labelTemplate = label
+	if v.config.ReverseDNS.Enabled {
+		v.resolver = newResolver(v.config.ReverseDNS)
+	}
+	if v.config.History > 0 || v.config.HistoryRetention > 0 {
+		v.history = &history{size: v.config.History, retention: v.config.HistoryRetention}
+	}
+	v.graphMu = &sync.RWMutex{}
+	v.createLocks(v.config.LockShards)
+	if err := v.seedTopology(); err != nil {
+		log.Fatalf("invalid discovery: %v", err)
+	}
+	v.createScenario()
+	v.startIngestion()
+	go v.snapshotLoop()
+	if v.config.Dump.Interval > 0 {
+		go v.dumpLoop()
+	}
+	if v.config.Discovery.Interval > 0 && !v.config.StrictConnections {
+		go v.discoveryLoop()
+	}
+	return v
+}
+
+func (v *Vizceral) createScenario() {
+	// clients remembers the client entry each connection came from
+	// so that duplicates can be reported with both sources
+	clients := make(map[string]string)
+	for tierName, tier := range v.config.Ships {
+		node := v.addNode(tierName, "region")
+		if tier.Type != "" {
+			node.metadata().Type = tier.Type
+		}
+		log.Printf("created tier %s (0)", tierName)
+		for _, con := range tier.Clients {
+			host, _, err := net.SplitHostPort(con)
+			if err != nil {
+				log.Fatalf("%s is not a valid remote host", con)
+			}
+			target := host
+			if v.config.KeepPorts {
+				target = con
+			}
+			connectionHash := v.key(tierName, target)
+			if first, ok := clients[connectionHash]; ok {
+				switch v.config.DuplicateConnections {
+				case duplicateMerge:
+					log.Printf("merging duplicate connection %s from %s and %s", connectionHash, first, con)
+					continue
+				case duplicateFail:
+					log.Fatalf("duplicate connection %s from %s and %s", connectionHash, first, con)
+				default:
+					log.Printf("warning: duplicate connection %s from %s and %s, replacing it", connectionHash, first, con)
+				}
+			}
+			clients[connectionHash] = con
+			log.Printf("creating connection %s:%s", tierName, target)
+			connection := v.addConnection(tierName, target)
+			conf, ok := tier.Connections[target]
+			if !ok {
+				conf, ok = tier.Connections[host]
+			}
+			if ok {
+				connection.suppressAlerts = conf.SuppressAlerts
+				connection.forceNormal = conf.ForceNormal
+				connection.deadman = conf.Deadman
+				connection.expectedRate = conf.ExpectedRate
+				if conf.SampleRate < 0 || conf.SampleRate > 1 || conf.SampleRate > 0 && conf.SampleRate*sampleScale < 1 {
+					log.Fatalf("sampleRate of connection %s:%s must be between 0.000001 and 1", tierName, target)
+				}
+				connection.sampleRate = conf.SampleRate
+				connection.latencySLO = conf.LatencySLOMs
+			}
+		}
+	}
+	for tierName, tier := range v.config.Ships {
+		v.createChildren(tierName, tier)
+	}
+	v.createEntry()
+	if v.config.Loopback {
+		v.addNode(loopbackName, "region")
+		v.addConnection(loopbackName, loopbackName)
+		log.Printf("created loopback connection %s:%s", loopbackName, loopbackName)
+	}
+	v.checkTargets()
+	v.groupNodes()
+}
+
+// defaultGroup is the layer of the nodes whose tier has no group
+const defaultGroup = "default"
+
+// groupNodes sets the group of every node when any tier has one
+func (v *Vizceral) groupNodes() {
+	for _, ship := range v.config.Ships {
+		if ship.Group != "" {
+			v.grouped = true
+		}
+	}
+	if !v.grouped {
+		return
+	}
+	for name, node := range v.NodeMap.nodes {
+		v.setGroup(name, node)
+	}
+}
+
+// setGroup sets the group of a node from the tier it belongs to
+func (v *Vizceral) setGroup(name string, node *VizceralNode) {
+	group := v.config.Ships[name].Group
+	if group == "" {
+		group = defaultGroup
+	}
+	node.metadata().Group = group
+}
+
+// Ways of handling clients that generate the same connection key
+const (
+	duplicateWarn  = "warn"
+	duplicateMerge = "merge"
+	duplicateFail  = "fail"
+)
+
+// createEntry adds the entry node and its connections into the
+// tiers that receive external traffic
+func (v *Vizceral) createEntry() {
+	entry := v.config.Entry
+	if !entry.Enabled {
+		return
+	}
+	name := entry.name()
+	v.addNode(name, entry.renderer())
+	v.EntryNode = name
+	log.Printf("created entry node %s", name)
+	for _, target := range entry.Targets {
+		log.Printf("creating connection %s:%s", name, target)
+		v.addConnection(name, target)
+	}
+	v.createOrigins()
+}
+
+// addNode creates a node with the given name and renderer
+func (v *Vizceral) addNode(name, renderer string) *VizceralNode {
+	node := &VizceralNode{}
+	node.Name = name
+	node.Renderer = renderer
+	v.NodeMap.nodes[name] = node
+	return node
+}
+
+// key returns the key of the connection between source and target
+func (v *Vizceral) key(source, target string) string {
+	return source + v.config.keySeparator() + target
+}
+
+// addConnection creates a connection between source and target
+func (v *Vizceral) addConnection(source, target string) *VizceralConnection {
+	connectionHash := v.key(source, target)
+	connection := &VizceralConnection{createdAt: time.Now(), ingested: &ingested{}}
+	connection.Source = source
+	connection.Target = target
+	connection.mu = v.lockFor(connectionHash)
+	v.ConnectionMap.connections[connectionHash] = connection
+	return connection
+}
+
+// checkTargets finds connections whose target is not a known tier.
+// These are either given a placeholder node or reported as dangling
+func (v *Vizceral) checkTargets() {
+	var dangling []string
+	for _, con := range v.ConnectionMap.connections {
+		if _, ok := v.NodeMap.nodes[con.Target]; ok {
+			continue
+		}
+		if v.config.PlaceholderNodes {
+			v.addNode(con.Target, "region")
+			log.Printf("created placeholder tier %s for %s:%s", con.Target, con.Source, con.Target)
+			continue
+		}
+		dangling = append(dangling, v.key(con.Source, con.Target))
+	}
+	if len(dangling) > 0 {
+		sort.Strings(dangling)
+		log.Printf("warning: connections target undefined tiers: %s", strings.Join(dangling, ", "))
+	}
+}
+
+// loopbackName is the node of the synthetic self connection
+const loopbackName = "cargo"
+
+// snapshotInterval is how often shadowMetrics are rotated into Metrics
+const snapshotInterval = time.Minute
+
+// snapshotLoop takes a snapshot every interval. With AlignSnapshots
+// it first waits for the next wall clock boundary of the interval so
+// that every instance rotates at the same instant
+func (v *Vizceral) snapshotLoop() {
+	if v.config.AlignSnapshots {
+		now := time.Now()
+		timer := time.NewTimer(now.Truncate(snapshotInterval).Add(snapshotInterval).Sub(now))
+		<-timer.C
+		v.tick()
+		ticker := time.NewTicker(snapshotInterval)
+		for range ticker.C {
+			v.tick()
+		}
+	}
+	for {
+		time.Sleep(snapshotInterval)
+		v.tick()
+	}
+}
+
+// tick takes a snapshot, retries the buffered integration requests
+// and logs the loopback connection
+func (v *Vizceral) tick() {
+	v.snapshot()
+	v.outbound.retry()
+	if v.config.Loopback {
+		v.logConnection(v.key(loopbackName, loopbackName), increment{metrics: Metrics{Normal: observationWeight}})
+	}
+}
+
+// snapshot rotates the shadowMetrics of every connection into Metrics
+func (v *Vizceral) snapshot() {
+	v.graphMu.Lock()
+	defer v.graphMu.Unlock()
+	if v.inMaintenance() {
+		v.discardWindow()
+		atomic.StoreInt64(&v.lastSnapshot, time.Now().UnixNano())
+		log.Printf("in maintenance, kept the previous snapshot")
+		return
+	}
+	now := time.Now()
+	v.sequence++
+	if v.config.Sequence {
+		v.Sequence = v.sequence
+	}
+	volumes := make([]int, 0, len(v.ConnectionMap.connections))
+	var classVolumes map[string][]int
+	if v.config.Volume.PerClass {
+		classVolumes = make(map[string][]int)
+	}
+	for _, con := range v.ConnectionMap.connections {
+		previous, previousClass := con.Metrics, con.Class
+		// There is a race condition here that the original
+		// connection object may receive some new observations
+		// before we create a new metric instance, and therefore
+		// we might lose a few observations. To avoid, the
+		// connection's (possibly shared) mutex is held
+		con.mu.Lock()
+		if con.shadowMetrics.Sum() > 0 {
+			con.lastSeen = now.Unix()
+		}
+		con.settle(v.config.Settling, now)
+		con.rotate(v.config)
+		con.mu.Unlock()
+
+		con.ErrorRate = con.Metrics.errorRate()
+		con.WarnRate = con.Metrics.warnRate()
+		if v.config.Rates {
+			con.Rate = con.Metrics.rate(con.window())
+		}
+		v.classify(con)
+		v.label(con)
+		if !con.Metrics.equal(previous) || con.Class != previousClass {
+			con.changed = now.Unix()
+			con.changedSequence = v.sequence
+		}
+		weighted := v.config.Volume.weighted(con.Metrics)
+		volumes = append(volumes, v.config.Volume.capped(weighted.Sum()))
+		if classVolumes != nil {
+			classVolumes[classNormal] = append(classVolumes[classNormal], v.config.Volume.capped(weighted.Normal))
+			classVolumes[classWarning] = append(classVolumes[classWarning], v.config.Volume.capped(weighted.Warning))
+			classVolumes[classDanger] = append(classVolumes[classDanger], v.config.Volume.capped(weighted.Danger))
+		}
+	}
+	v.snapshotChildren(now)
+	volume := v.config.Volume.maxVolume(volumes)
+	v.MaxVolume = volume
+	if classVolumes != nil {
+		v.MaxVolumes = make(map[string]int, len(classVolumes))
+		for class, volumes := range classVolumes {
+			v.MaxVolumes[class] = v.config.Volume.maxVolume(volumes)
+		}
+	}
+	if v.config.NodeVolumes {
+		v.splitNodeVolumes()
+	}
+	v.classifyNodes()
+	v.checkSLOs()
+	v.checkSaturation()
+	v.emitVolumes()
+	v.sizeNodes()
+	v.limitNotices()
+	for _, p := range v.postProcess {
+		p(v)
+	}
+
+	v.updateTimestamp()
+	atomic.StoreInt64(&v.lastSnapshot, now.UnixNano())
+	atomic.StoreInt32(&v.rotated, 1)
+	v.promoteHotLocks()
+	atomic.StoreInt64(&v.connectionCount, int64(len(v.ConnectionMap.connections)))
+	atomic.StoreInt64(&v.nodeCount, int64(len(v.NodeMap.nodes)))
+	v.ServerUpdateTime = now.UnixNano() / int64(time.Millisecond)
+	v.recordHistory(now.Unix())
+
+	v.logSnapshot(volume)
+}
+
+// discardWindow drops the in progress window of every connection
+func (v *Vizceral) discardWindow() {
+	for _, con := range v.allConnections() {
+		con.mu.Lock()
+		con.shadowMetrics = Metrics{}
+		con.shadowLatency = latencySamples{}
+		con.shadowDimensions = nil
+		con.shadowClusters = nil
+		con.shadowFailures = nil
+		con.shadowBytes = nil
+		con.shadowLatencySLO = LatencySLO{}
+		con.mu.Unlock()
+	}
+}
+
+// setMaintenance freezes or resumes the graph
+func (v *Vizceral) setMaintenance(on bool) {
+	var flag int32
+	if on {
+		flag = 1
+	}
+	atomic.StoreInt32(&v.maintenance, flag)
+}
+
+func (v *Vizceral) inMaintenance() bool {
+	return atomic.LoadInt32(&v.maintenance) == 1
+}
+
+// setDraining stops or resumes accepting log events
+func (v *Vizceral) setDraining(on bool) {
+	var flag int32
+	if on {
+		flag = 1
+	}
+	atomic.StoreInt32(&v.draining, flag)
+}
+
+func (v *Vizceral) isDraining() bool {
+	return atomic.LoadInt32(&v.draining) == 1
+}
+
+// classifyNodes gives every node the worst class of the connections
+// into and out of it, and marks whether it has any connections
+func (v *Vizceral) classifyNodes() {
+	classes := make(map[string]string)
+	connected := make(map[string]bool)
+	for _, con := range v.ConnectionMap.connections {
+		for _, name := range []string{con.Source, con.Target} {
+			classes[name] = worse(classes[name], con.Class)
+			connected[name] = true
+		}
+	}
+	for name, node := range v.NodeMap.nodes {
+		node.Class = classes[name]
+		node.Connected = connected[name]
+	}
+}
+
+// splitNodeVolumes sets the inbound and outbound volume of every node
+func (v *Vizceral) splitNodeVolumes() {
+	in := make(map[string]int)
+	out := make(map[string]int)
+	for _, con := range v.ConnectionMap.connections {
+		in[con.Target] += con.Metrics.Sum()
+		out[con.Source] += con.Metrics.Sum()
+	}
+	for name, node := range v.NodeMap.nodes {
+		volumeIn, volumeOut := in[name], out[name]
+		metadata := node.metadata()
+		if replicas := v.config.Ships[name].Replicas; v.config.NormalizeReplicas && replicas > 1 {
+			totalIn, totalOut := volumeIn, volumeOut
+			metadata.VolumeInTotal = &totalIn
+			metadata.VolumeOutTotal = &totalOut
+			volumeIn, volumeOut = volumeIn/replicas, volumeOut/replicas
+		}
+		metadata.VolumeIn = &volumeIn
+		metadata.VolumeOut = &volumeOut
+	}
+}
+
+// logSnapshot reports a completed snapshot, the per class counts
+// are only computed in verbose mode
+func (v *Vizceral) logSnapshot(volume int) {
+	if !v.config.Verbose {
+		log.Printf("took a snapshot with total volume = %d", volume)
+		return
+	}
+	classes := make(map[string]int)
+	for _, con := range v.ConnectionMap.connections {
+		classes[con.class]++
+	}
+	log.Printf("took a snapshot with total volume = %d, nodes = %d, connections = %d (normal = %d, warning = %d, danger = %d)",
+		volume, len(v.NodeMap.nodes), len(v.ConnectionMap.connections),
+		classes[classNormal], classes[classWarning], classes[classDanger])
+}
+
+// classify computes the class of a connection from its committed
+// metrics and latency, and logs an alert when it moves between classes
+func (v *Vizceral) classify(con *VizceralConnection) {
+	class := v.metricsClass(con)
+	if con.silentFor = v.silence(con); con.silentFor > 0 {
+		class = classDanger
+	}
+	deviation := v.volumeDeviation(con)
+	if deviation != nil && v.config.ExpectedVolume.Warning {
+		class = worse(class, classWarning)
+	}
+	if con.settling {
+		deviation = nil
+		con.silentFor = 0
+		if class != "" {
+			class = classNormal
+		}
+	}
+	if class != con.class && con.class != "" && !con.suppressAlerts {
+		v.alert(con, con.class, class)
+	}
+	con.class = class
+	con.Class = class
+	if con.forceNormal && class != "" {
+		con.Class = classNormal
+	}
+	v.sustainedDanger(con)
+	if deviation != nil {
+		con.Notices = append(con.Notices, *deviation)
+	}
+	v.pagerDuty(con)
+}
+
+// metricsClass returns the class of a connection given by its committed
+// metrics and latency alone, through the classification, ColorBy or the
+// thresholds, learning the adaptive baseline
+func (v *Vizceral) metricsClass(con *VizceralConnection) string {
+	metrics := v.classMetrics(con)
+	var class string
+	if v.classification != nil {
+		class = v.expressionClass(con, metrics)
+	} else if v.config.ColorBy.Metric != "" {
+		class = v.colorClass(con, metrics)
+	} else {
+		errorClass := v.config.Thresholds.classOf(metrics)
+		if adaptive := v.config.Adaptive; adaptive.Enabled {
+			errorClass = adaptive.classOf(&con.baseline, metrics)
+			if metrics.Sum() > 0 && !con.settling {
+				con.baseline.add(metrics.errorRate(), adaptive.windows())
+			}
+		}
+		class = worse(errorClass, v.config.Latency.classOf(con.Latency))
+	}
+	if class != "" && con.Metrics.observations() < v.config.Thresholds.MinObservations {
+		class = classNormal
+	}
+	return class
+}
+
+// sustainedDanger attaches a notice to a connection that has been in
+// danger for at least SustainedDanger snapshots and clears it once
+// the connection recovers. A connection silent beyond its deadman
+// interval is given a no data notice instead
+func (v *Vizceral) sustainedDanger(con *VizceralConnection) {
+	if con.class != classDanger {
+		con.dangerStreak = 0
+		v.showNotice(con, nil, 0)
+		return
+	}
+	con.dangerStreak++
+	if con.silentFor > 0 && !con.forceNormal {
+		v.showNotice(con, &Notice{
+			Title:    fmt.Sprintf("no data for %s", con.silentFor.Truncate(time.Minute)),
+			Severity: noticeDanger,
+		}, con.dangerStreak)
+		return
+	}
+	if v.config.SustainedDanger <= 0 || con.dangerStreak < v.config.SustainedDanger || con.forceNormal {
+		v.showNotice(con, nil, 0)
+		return
+	}
+	minutes := int(time.Duration(con.dangerStreak) * snapshotInterval / time.Minute)
+	v.showNotice(con, &Notice{
+		Title:    fmt.Sprintf("%d consecutive minutes of elevated errors", minutes),
+		Severity: noticeDanger,
+	}, con.dangerStreak)
+}
+
+// silence returns how long a connection has gone without data when
+// that exceeds its deadman interval, and 0 otherwise. Connections never
+// logged count from the start of the collector
+func (v *Vizceral) silence(con *VizceralConnection) time.Duration {
+	deadman := con.deadman
+	if deadman <= 0 {
+		deadman = v.config.Deadman
+	}
+	if deadman <= 0 {
+		return 0
+	}
+	last := v.started
+	if con.lastSeen > 0 {
+		last = time.Unix(con.lastSeen, 0)
+	}
+	if silent := time.Since(last); silent > deadman {
+		return silent
+	}
+	return 0
+}
+
+// classMetrics returns the metrics a connection is classified by,
+// where warnings count as danger when WarningAsDanger is set
+func (v *Vizceral) classMetrics(con *VizceralConnection) Metrics {
+	m := con.Metrics
+	if v.config.WarningAsDanger {
+		m.Danger += m.Warning
+		m.Warning = 0
+	}
+	return m
+}
+
+// isStale reports whether the last snapshot is older than the
+// configured threshold, which suggests the snapshot loop has stalled
+func (v *Vizceral) isStale() bool {
+	last := time.Unix(0, atomic.LoadInt64(&v.lastSnapshot))
+	return time.Since(last) > v.config.staleAfter()
+}
+
+// refreshed returns a copy of the graph with the fields derived at
+// read time updated. Its nodes are copied so that the graph itself is
+// left untouched, readers only hold graphMu for reading
+func (v *Vizceral) refreshed() *Vizceral {
+	view := *v
+	view.Stale = v.isStale()
+	view.Maintenance = v.inMaintenance()
+	view.NodeMap = &VizceralNodes{nodes: make(map[string]*VizceralNode, len(v.NodeMap.nodes))}
+	for key, node := range v.NodeMap.nodes {
+		copied := *node
+		if node.Nodes != nil {
+			copied.Nodes = &VizceralNodes{nodes: make(map[string]*VizceralNode, len(node.Nodes.nodes))}
+			for name, child := range node.Nodes.nodes {
+				service := *child
+				copied.Nodes.nodes[name] = &service
+			}
+		}
+		view.NodeMap.nodes[key] = &copied
+	}
+	view.updateTimestamp()
+	return &view
+}
+
+func (v *Vizceral) updateTimestamp() {
+	t := time.Now()
+	now := t.Unix()
+	updatedAt := ""
+	if v.location != nil {
+		updatedAt = t.In(v.location).Format(time.RFC3339)
+	}
+	v.Updated = now
+	v.UpdatedAt = updatedAt
+	for _, node := range v.NodeMap.nodes {
+		node.Updated = now
+		node.UpdatedAt = updatedAt
+		if node.Nodes != nil {
+			for _, child := range node.Nodes.nodes {
+				child.Updated = now
+				child.UpdatedAt = updatedAt
+			}
+		}
+	}
+}
+
+// MarshalJSON flattens this map into an array, ordered by key
+func (nodes VizceralConnections) MarshalJSON() (resp []byte, err error) {
+	keys := make([]string, 0, len(nodes.connections))
+	for key := range nodes.connections {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var listOfNodes []*VizceralConnection
+	for _, key := range keys {
+		listOfNodes = append(listOfNodes, nodes.connections[key])
+	}
+
+	return json.Marshal(listOfNodes)
+}
+
+// MarshalJSON flattens this map into an array, ordered by key
+func (nodes VizceralNodes) MarshalJSON() (resp []byte, err error) {
+	keys := make([]string, 0, len(nodes.nodes))
+	for key := range nodes.nodes {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	var listOfNodes []*VizceralNode
+	for _, key := range keys {
+		listOfNodes = append(listOfNodes, nodes.nodes[key])
+	}
+
+	return json.Marshal(listOfNodes)
+}
@@ -0,0 +1,59 @@
+package collector
+
+import (
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// fileWatcher detects changes to a file by polling it. Symlinks are
+// resolved on every check, so a file reached through a symlink that
+// is swapped to a new target, as Kubernetes does for ConfigMaps with
+// its ..data directory, is seen as changed even when the new file has
+// the same size and modification time as the old one
+type fileWatcher struct {
+	path    string
+	target  string
+	modTime time.Time
+	size    int64
+}
+
+func newFileWatcher(path string) *fileWatcher {
+	w := &fileWatcher{path: path}
+	w.changed()
+	return w
+}
+
+// changed reports whether the file differs from the last check
+// A file that cannot be read, such as during a swap, is left unchanged
+func (w *fileWatcher) changed() bool {
+	target, err := filepath.EvalSymlinks(w.path)
+	if err != nil {
+		return false
+	}
+	info, err := os.Stat(target)
+	if err != nil {
+		return false
+	}
+	if target == w.target && info.ModTime().Equal(w.modTime) && info.Size() == w.size {
+		return false
+	}
+	w.target, w.modTime, w.size = target, info.ModTime(), info.Size()
+	return true
+}
+
+// WatchFile calls onChange whenever the file at path changes,
+// checking it every interval
+func WatchFile(path string, interval time.Duration, onChange func()) {
+	w := newFileWatcher(path)
+	log.Printf("watching %s for changes every %s", path, interval)
+	go func() {
+		for {
+			time.Sleep(interval)
+			if w.changed() {
+				onChange()
+			}
+		}
+	}()
+}
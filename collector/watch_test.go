@@ -0,0 +1,72 @@
+package collector
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// writeVersion creates a ConfigMap style versioned directory holding conf.yaml
+func writeVersion(t *testing.T, dir, version, content string, modTime time.Time) {
+	t.Helper()
+	versionDir := filepath.Join(dir, version)
+	if err := os.Mkdir(versionDir, 0755); err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(versionDir, "conf.yaml")
+	if err := ioutil.WriteFile(file, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chtimes(file, modTime, modTime); err != nil {
+		t.Fatal(err)
+	}
+}
+
+// swapData atomically points ..data at version, as the kubelet does
+func swapData(t *testing.T, dir, version string) {
+	t.Helper()
+	tmp := filepath.Join(dir, "..data_tmp")
+	if err := os.Symlink(version, tmp); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Rename(tmp, filepath.Join(dir, "..data")); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFileWatcherSymlinkSwap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "cargo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// Both versions share size and modification time so that only the
+	// resolved symlink target tells them apart
+	modTime := time.Now().Add(-time.Hour).Truncate(time.Second)
+	writeVersion(t, dir, "..2026_01_01", "history: 10\n", modTime)
+	writeVersion(t, dir, "..2026_01_02", "history: 20\n", modTime)
+	swapData(t, dir, "..2026_01_01")
+	path := filepath.Join(dir, "conf.yaml")
+	if err := os.Symlink(filepath.Join("..data", "conf.yaml"), path); err != nil {
+		t.Fatal(err)
+	}
+
+	w := newFileWatcher(path)
+	if w.changed() {
+		t.Fatal("changed before the swap")
+	}
+	swapData(t, dir, "..2026_01_02")
+	if !w.changed() {
+		t.Fatal("swap of ..data was not detected")
+	}
+	if w.changed() {
+		t.Fatal("changed again without a swap")
+	}
+	os.RemoveAll(filepath.Join(dir, "..2026_01_01"))
+	if w.changed() {
+		t.Fatal("removing the old version was detected as a change")
+	}
+}
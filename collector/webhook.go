@@ -0,0 +1,99 @@
+package collector
+
+import (
+	"encoding/json"
+	"log"
+	"time"
+)
+
+// WebhookConfig posts class changes of connections to URL, which is
+// left out of /config as it often carries a token
+// Volumes also posts the volume of the connections after every
+// snapshot. MinChange leaves out of those the connections whose volume
+// changed by no more than that many percent since it was last posted
+// for them, class changes are always posted. It only applies to the
+// webhook, PagerDuty is only sent triggers and resolves
+type WebhookConfig struct {
+	URL       string  `yaml:"url" json:"-"`
+	Volumes   bool    `yaml:"volumes" json:"volumes"`
+	MinChange float64 `yaml:"minChange" json:"minChange"`
+}
+
+// classChange is the webhook payload sent when a connection changes class
+type classChange struct {
+	Source  string `json:"source"`
+	Target  string `json:"target"`
+	From    string `json:"from"`
+	To      string `json:"to"`
+	Updated int64  `json:"updated"`
+}
+
+// alert reports a connection moving between classes in the log and,
+// when configured, to the webhook. The webhook is called in the
+// background so a slow endpoint cannot stall the snapshot loop
+// During the configured Warmup after start the webhook is not called
+func (v *Vizceral) alert(con *VizceralConnection, from, to string) {
+	log.Printf("alert: connection %s:%s changed class %s -> %s", con.Source, con.Target, from, to)
+	url := v.config.Webhook.URL
+	if url == "" {
+		return
+	}
+	if time.Since(v.started) < v.config.Warmup {
+		log.Printf("not calling webhook during warmup")
+		return
+	}
+	body, err := json.Marshal(classChange{
+		Source:  con.Source,
+		Target:  con.Target,
+		From:    from,
+		To:      to,
+		Updated: time.Now().Unix(),
+	})
+	if err != nil {
+		log.Printf("failed to convert class change into JSON: %v", err)
+		return
+	}
+	v.outbound.postAsync("webhook", url, "application/json", body)
+}
+
+// connectionVolume is the volume of a connection in a volumes payload
+type connectionVolume struct {
+	Source string `json:"source"`
+	Target string `json:"target"`
+	Volume int    `json:"volume"`
+	Class  string `json:"class"`
+}
+
+// volumes is the webhook payload sent after every snapshot with Volumes
+type volumes struct {
+	Event       string             `json:"event"`
+	Connections []connectionVolume `json:"connections"`
+	Updated     int64              `json:"updated"`
+}
+
+// emitVolumes posts the volume of the connections to the webhook,
+// leaving out those that changed by no more than MinChange. Nothing
+// is posted when no connection is left or during the Warmup
+func (v *Vizceral) emitVolumes() {
+	conf := v.config.Webhook
+	if conf.URL == "" || !conf.Volumes || time.Since(v.started) < v.config.Warmup {
+		return
+	}
+	payload := volumes{Event: "volumes", Updated: time.Now().Unix()}
+	for key, con := range v.ConnectionMap.connections {
+		volume := con.Metrics.Sum()
+		if !v.webhookFilter.allow(key, volume) {
+			continue
+		}
+		payload.Connections = append(payload.Connections, connectionVolume{con.Source, con.Target, volume, con.Class})
+	}
+	if len(payload.Connections) == 0 {
+		return
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		log.Printf("failed to convert volumes into JSON: %v", err)
+		return
+	}
+	v.outbound.postAsync("webhook", conf.URL, "application/json", body)
+}
@@ -0,0 +1,46 @@
+package collector
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestWebhookClassChangeAtConstantVolume(t *testing.T) {
+	changes := make(chan classChange, 10)
+	hook := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var change classChange
+		if err := json.NewDecoder(r.Body).Decode(&change); err != nil {
+			t.Errorf("failed to decode webhook payload: %v", err)
+		}
+		changes <- change
+	}))
+	defer hook.Close()
+	v, server := newTestServer(t, Config{
+		Ships: map[string]Ship{
+			"web": {Clients: []string{"10.0.0.1:80"}},
+		},
+		Thresholds: Thresholds{Warning: 0.1, Danger: 0.5},
+		Webhook:    WebhookConfig{URL: hook.URL, MinChange: 50},
+	})
+
+	for _, bucket := range []string{"complete", "failed", "complete"} {
+		for i := 0; i < 4; i++ {
+			post(t, server.URL+"/log/"+bucket+"/web:10.0.0.1", http.StatusOK)
+		}
+		v.snapshot()
+	}
+
+	for _, want := range []classChange{{From: classNormal, To: classDanger}, {From: classDanger, To: classNormal}} {
+		select {
+		case change := <-changes:
+			if change.Source != "web" || change.Target != "10.0.0.1" || change.From != want.From || change.To != want.To {
+				t.Errorf("webhook got %+v, want %s -> %s", change, want.From, want.To)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("webhook was not called for %s -> %s", want.From, want.To)
+		}
+	}
+}